@@ -2,6 +2,11 @@ module pingotrace
 
 go 1.22.1
 
+require (
+	golang.org/x/net v0.17.0
+	golang.org/x/sys v0.13.0
+)
+
 require (
 	fyne.io/fyne/v2 v2.4.4 // indirect
 	fyne.io/systray v1.10.1-0.20231115130155-104f5ef7839e // indirect
@@ -26,8 +31,6 @@ require (
 	github.com/yuin/goldmark v1.5.5 // indirect
 	golang.org/x/image v0.11.0 // indirect
 	golang.org/x/mobile v0.0.0-20230531173138-3c911d8e3eda // indirect
-	golang.org/x/net v0.17.0 // indirect
-	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	honnef.co/go/js/dom v0.0.0-20210725211120-f030747120f2 // indirect
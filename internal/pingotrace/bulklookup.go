@@ -0,0 +1,119 @@
+package pingotrace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Wait before asking again for a lookup that failed
+const lookupRetryDelay = 250 * time.Millisecond
+
+// DefaultParallel is how many lookups, queries or connections the bulk functions have in flight at once.
+const DefaultParallel = 32
+
+// ForEachParallel calls fn with every index below n from a pool of at most parallel goroutines, and at least one,
+// and returns once every call has returned. The bulk functions run through it so that lists of thousands of hosts
+// neither flood the network nor start thousands of goroutines.
+func ForEachParallel(n, parallel int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range max(1, min(parallel, n)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// LookupOptions controls how BulkLookup resolves a list of inputs.
+type LookupOptions struct {
+	Server   string        // DNS server, "host:port", or "" for the system resolver
+	Parallel int           // Lookups in flight at once; 0 or 1 resolves one input after the other
+	Timeout  time.Duration // Limit of each attempt, 0 for none but the context
+	Retries  int           // Extra attempts after a TIMEOUT, SERVFAIL or ERROR; NXDOMAIN, NODATA and REFUSED are final
+
+	// Progress, if set, is called after each input is resolved with the number of inputs done so far.
+	// Calls are made one at a time from the lookup goroutines, so it should return quickly.
+	Progress func(done, total int, result LookupResult)
+}
+
+// DefaultLookupOptions returns the options used by DNSPTR: the system resolver, DefaultParallel lookups at once,
+// 5 seconds per attempt and one retry.
+func DefaultLookupOptions() LookupOptions {
+	return LookupOptions{
+		Parallel: DefaultParallel,
+		Timeout:  5 * time.Second,
+		Retries:  1,
+	}
+}
+
+// BulkLookup performs the Lookup of every input with a pool of opts.Parallel goroutines, as ForEachParallel does.
+// It returns the results in the order of the inputs once every started lookup has returned. When ctx is cancelled
+// no new lookup is started and the inputs not resolved yet get a TIMEOUT result.
+func BulkLookup(ctx context.Context, inputs []string, opts LookupOptions) []LookupResult {
+	results := make([]LookupResult, len(inputs))
+	var progressMu sync.Mutex // Serializes the calls of opts.Progress
+	done := 0
+	ForEachParallel(len(inputs), opts.Parallel, func(i int) {
+		if ctx.Err() != nil {
+			results[i] = LookupResult{Input: inputs[i], Resolver: opts.Server, Err: &LookupError{Status: "TIMEOUT", Err: ctx.Err()}}
+			if CheckIP(inputs[i]) {
+				results[i].Kind = LookupPTR
+			}
+			return
+		}
+		results[i] = lookupRetry(ctx, inputs[i], opts)
+		if opts.Progress != nil {
+			progressMu.Lock()
+			done++
+			opts.Progress(done, len(inputs), results[i])
+			progressMu.Unlock()
+		}
+	})
+	return results
+}
+
+// lookupRetry performs the Lookup of input, asking again up to opts.Retries times while it fails for lack of an answer.
+// Retries bypass the cache, which keeps failures for a few seconds.
+func lookupRetry(ctx context.Context, input string, opts LookupOptions) LookupResult {
+	var result LookupResult
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(lookupRetryDelay):
+			case <-ctx.Done():
+				return result
+			}
+		}
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if opts.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		}
+		result = lookup(attemptCtx, input, opts.Server, attempt > 0)
+		cancel()
+
+		if result.Err == nil || ctx.Err() != nil || !isRetryable(result.Err) {
+			break
+		}
+	}
+	return result
+}
+
+// isRetryable reports whether a lookup failed in a way another attempt may fix.
+func isRetryable(err *LookupError) bool {
+	switch err.Status {
+	case "TIMEOUT", "SERVFAIL", "ERROR":
+		return true
+	}
+	return false
+}
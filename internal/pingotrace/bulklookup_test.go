@@ -0,0 +1,73 @@
+package pingotrace
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForEachParallel(t *testing.T) {
+	for _, tt := range []struct{ n, parallel, want int }{{100, 8, 8}, {3, 8, 3}, {5, 0, 1}, {0, 8, 0}} {
+		var mu sync.Mutex
+		running, most := 0, 0
+		calls := make([]int, tt.n)
+		ForEachParallel(tt.n, tt.parallel, func(i int) {
+			mu.Lock()
+			running++
+			most = max(most, running)
+			calls[i]++
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+		if most != tt.want {
+			t.Errorf("n %d parallel %d: %d calls at once, want %d", tt.n, tt.parallel, most, tt.want)
+		}
+		for i, count := range calls {
+			if count != 1 {
+				t.Errorf("n %d parallel %d: index %d called %d times", tt.n, tt.parallel, i, count)
+			}
+		}
+	}
+}
+
+func TestBulkLookup(t *testing.T) {
+	server := startFakeDNS(t, "127.0.0.1:0", zoneHandler("example.test.", testZone...))
+	FlushDNSCache()
+	defer FlushDNSCache()
+
+	opts := DefaultLookupOptions()
+	opts.Server = server
+	var progress []int
+	opts.Progress = func(done, total int, result LookupResult) {
+		progress = append(progress, done)
+	}
+	inputs := []string{"host.example.test", "192.0.2.1", "missing.example.test", "v6:www.example.test"}
+	want := []string{"192.0.2.1", "host.example.test", "", "2001:db8::1"}
+	results := BulkLookup(context.Background(), inputs, opts)
+	for i, result := range results {
+		if result.Input != inputs[i] || result.Answer() != want[i] {
+			t.Errorf("%s: got %v, want %q", inputs[i], result, want[i])
+		}
+	}
+	if results[2].Err == nil || results[2].Err.Status != "NXDOMAIN" {
+		t.Errorf("%s: error %v, want NXDOMAIN", inputs[2], results[2].Err)
+	}
+	if len(progress) != len(inputs) || progress[len(progress)-1] != len(inputs) {
+		t.Errorf("progress %v, want one call per input up to %d", progress, len(inputs))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, result := range BulkLookup(ctx, []string{"host.example.test", "192.0.2.1"}, opts) {
+		if result.Err == nil || result.Err.Status != "TIMEOUT" || result.Cached {
+			t.Errorf("%s after cancel: got %v, want TIMEOUT", result.Input, result)
+		}
+	}
+	if results[1].Kind != LookupPTR {
+		t.Errorf("%s: kind %v, want PTR", inputs[1], results[1].Kind)
+	}
+}
@@ -2,172 +2,330 @@ package pingotrace
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"slices"
 	"strings"
-	"sync"
+	"time"
 )
 
-// DNSLookup performs a DNS lookup on the provided host to get its IPv4 address.
-// It returns the resolved IP address and a boolean indicating if the lookup was successful.
-func DNSLookup(ctx context.Context, host string) (string, bool) {
-	resultChan := make(chan string) // Channel to receive the resolved IP address
-	errChan := make(chan error)     // Channel to receive any errors during resolution
+// LookupKind tells whether a lookup resolves a name or an address.
+type LookupKind int
+
+const (
+	LookupDNS LookupKind = iota // Name to addresses, A or AAAA records
+	LookupPTR                   // Address to names, PTR records
+)
+
+// String returns "DNS" or "PTR".
+func (k LookupKind) String() string {
+	if k == LookupPTR {
+		return "PTR"
+	}
+	return "DNS"
+}
+
+// LookupError is why a lookup found no answer.
+type LookupError struct {
+	// Status is the outcome as DNSAnswer.Status words it: "NXDOMAIN", "NODATA", "SERVFAIL", "REFUSED",
+	// "TIMEOUT" or "ERROR". The system resolver does not tell NODATA from NXDOMAIN, both are NXDOMAIN.
+	Status string
+	Err    error // Underlying error, nil when a server answered with Status
+}
 
-	// Goroutine to resolve the host to an IP address
-	go func() {
-		ipAddr, err := net.ResolveIPAddr("ip4", host) // Resolve to IPv4 address
+// Error returns the status followed by the underlying error, e.g. "NXDOMAIN (lookup example.invalid: no such host)".
+func (e *LookupError) Error() string {
+	if e.Err == nil {
+		return e.Status
+	}
+	return fmt.Sprintf("%s (%v)", e.Status, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *LookupError) Unwrap() error {
+	return e.Err
+}
+
+// LookupResult is the outcome of the DNS or PTR lookup of one input.
+type LookupResult struct {
+	Input    string     // Input as given, family prefix and port included
+	Kind     LookupKind // PTR for IP addresses, DNS otherwise
+	Answers  []string   // Addresses found by a DNS lookup or names found by a PTR lookup, without trailing dots
+	Err      *LookupError
+	Duration time.Duration
+	Resolver string // Address and port of the DNS server asked, "" for the system resolver
+	Cached   bool   // The result was taken from the lookup cache rather than asked again
+}
+
+// OK reports whether the lookup found an answer.
+func (r LookupResult) OK() bool {
+	return r.Err == nil && len(r.Answers) > 0
+}
+
+// Answer returns the answer the tools use: the preferred address of a name or the first name of an address.
+// It is empty if the lookup failed.
+func (r LookupResult) Answer() string {
+	if !r.OK() {
+		return ""
+	}
+	return r.Answers[0]
+}
+
+// String formats the result as "input: answer, answer" or "input: error".
+func (r LookupResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: %v", r.Input, r.Err)
+	}
+	return fmt.Sprintf("%s: %s", r.Input, strings.Join(r.Answers, ", "))
+}
+
+// Lookup performs a PTR lookup if input is an IP address and a DNS lookup otherwise, through the system
+// resolver if server is empty and with QueryDNS to server, "host:port", if not.
+// A DNS lookup returns the addresses of the family of a "v4:" or "v6:" prefix, or else every IPv4 address
+// followed by every IPv6 address, so that the first answer is the address DNSLookup prefers.
+// Results are cached for the TTL of their records, and failures for a short time; see FlushDNSCache.
+func Lookup(ctx context.Context, input, server string) LookupResult {
+	return lookup(ctx, input, server, false)
+}
+
+// lookup is Lookup, asking the resolver again rather than taking a cached result if refresh is set.
+func lookup(ctx context.Context, input, server string, refresh bool) LookupResult {
+	result := LookupResult{Input: input, Resolver: server}
+	target, _ := SplitPort(input)
+	host, family := SplitFamily(target)
+	host = strings.Trim(host, "[]")
+	if CheckIP(input) {
+		result.Kind = LookupPTR
+	}
+
+	startTime := time.Now()
+	key := dnsCacheKey{server: server, kind: result.Kind, family: family, host: strings.ToLower(host)}
+	if entry, ok := lookupCache.get(key, refresh); ok {
+		result.Answers, result.Err, result.Cached = slices.Clone(entry.answers), entry.err, true
+		result.Duration = time.Since(startTime)
+		return result
+	}
+
+	var ttl time.Duration
+	if server == "" {
+		ttl = lookupSystem(ctx, &result, host, family)
+		if ctx.Err() == nil && (result.Err == nil || isNegative(result.Err)) {
+			ttl = systemAnswerTTL(ctx, result, family, ttl)
+		}
+	} else {
+		ttl = lookupServer(ctx, &result, server, family)
+	}
+	result.Duration = time.Since(startTime)
+	if ctx.Err() == nil { // A cancelled lookup tells nothing about the name
+		lookupCache.put(key, result.Answers, result.Err, ttl)
+	}
+	return result
+}
+
+// lookupSystem fills in result with the answers of the system resolver for host.
+// It returns how long the result may be cached.
+func lookupSystem(ctx context.Context, result *LookupResult, host string, family AddressFamily) time.Duration {
+	if result.Kind == LookupPTR {
+		names, err := net.DefaultResolver.LookupAddr(ctx, host)
 		if err != nil {
-			errChan <- err
-			return
+			result.Err = systemLookupError(ctx, err)
+			return systemTTL(result.Err)
+		}
+		for _, name := range names {
+			result.Answers = append(result.Answers, RemoveTrailingDot(name))
 		}
-		resultChan <- ipAddr.String()
-	}()
+		return dnsCacheSystemTTL
+	}
 
-	// Wait for a result, an error, or a cancellation signal
-	select {
-	case res := <-resultChan:
-		return res, true
-	case err := <-errChan:
-		return err.Error(), false
-	case <-ctx.Done():
-		return "No DNS record found", false
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		result.Err = systemLookupError(ctx, err)
+		return systemTTL(result.Err)
+	}
+	var ipv4, ipv6 []string
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ipv4 = append(ipv4, addr.IP.String())
+		} else {
+			ipv6 = append(ipv6, addr.String())
+		}
+	}
+	switch family {
+	case FamilyIPv4:
+		result.Answers = ipv4
+	case FamilyIPv6:
+		result.Answers = ipv6
+	default:
+		result.Answers = append(ipv4, ipv6...)
+	}
+	if len(result.Answers) == 0 {
+		version := "IPv4"
+		if family == FamilyIPv6 {
+			version = "IPv6"
+		}
+		result.Err = &LookupError{Status: "NODATA", Err: fmt.Errorf("%s has no %s address", host, version)}
+		return dnsCacheNegativeTTL
 	}
+	return dnsCacheSystemTTL
 }
 
-// PTRLookup performs a reverse DNS lookup (PTR) on the provided IPv4 address to get its associated domain name.
-// It returns the associated domain name and a boolean indicating if the lookup was successful.
-func PTRLookup(ctx context.Context, ipAddr string) (string, bool) {
-	resultChan := make(chan string) // Channel to receive the domain name
-	errChan := make(chan error)     // Channel to receive any errors during lookup
-
-	// Goroutine to get the domain name associated with the IP address
-	go func() {
-		names, err := net.LookupAddr(ipAddr) // Perform reverse DNS lookup
-		if err != nil || len(names) == 0 {
-			errChan <- err
-			return
+// systemAnswerTTL returns how long result, found by the system resolver, may be cached: the TTL the name server
+// of the system tells when asked directly, if it gives the same answer, or else ttl. The system resolver may have
+// taken the answer from the hosts file, a search domain or another server, whose TTLs are unknown.
+func systemAnswerTTL(ctx context.Context, result LookupResult, family AddressFamily, ttl time.Duration) time.Duration {
+	server := systemTTLServer()
+	if server == "" {
+		return ttl
+	}
+	ctx, cancel := context.WithTimeout(ctx, systemTTLTimeout)
+	defer cancel()
+	direct := LookupResult{Input: result.Input, Kind: result.Kind}
+	directTTL := lookupServer(ctx, &direct, server, family)
+	switch {
+	case direct.Err != nil && direct.Err.Status == "TIMEOUT":
+		if ctx.Err() != context.Canceled {
+			systemTTLServerFailed(server)
 		}
-		// Trim trailing dot from the domain name
-		resultChan <- strings.TrimSuffix(names[0], ".")
-	}()
+	case result.Err == nil && direct.Err == nil && sameAnswers(result.Answers, direct.Answers),
+		result.Err != nil && direct.Err != nil && result.Err.Status == direct.Err.Status:
+		return directTTL
+	}
+	return ttl
+}
 
-	// Wait for a result, an error, or a cancellation signal
-	select {
-	case res := <-resultChan:
-		return res, true
-	case <-errChan:
-		return "PTR record lookup timed out", false
-	case <-ctx.Done():
-		return "PTR record lookup timed out", false
+// sameAnswers reports whether a and b hold the same answers in any order.
+func sameAnswers(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// systemTTL returns how long a failed lookup of the system resolver may be cached.
+func systemTTL(err *LookupError) time.Duration {
+	if isNegative(err) {
+		return dnsCacheNegativeTTL
 	}
+	return dnsCacheFailureTTL
 }
 
-// DNSPTR performs DNS and PTR lookups based on the inputs provided.
-// If an input is an IP address, it will perform a PTR lookup. Otherwise, it does a DNS lookup.
-// It returns a map containing the results and a slice of keys (inputs) in their original order.
-func DNSPTR(ctx context.Context, inputs []string) (map[string][]interface{}, []string) {
-	results := make(map[string][]interface{}) // Map to store the results
-	keys := make([]string, 0, len(inputs))    // Slice to track the order of inputs
-
-	var wg sync.WaitGroup // Synchronize goroutines
-	// Struct for passing results between goroutines
-	resultChan := make(chan struct {
-		key     string
-		address string
-		success bool
-	})
+// systemLookupError classifies an error of the system resolver.
+func systemLookupError(ctx context.Context, err error) *LookupError {
+	var dnsErr *net.DNSError
+	isDNSErr := errors.As(err, &dnsErr)
+	switch {
+	case ctx.Err() != nil || isDNSErr && dnsErr.IsTimeout:
+		return &LookupError{Status: "TIMEOUT", Err: err}
+	case isDNSErr && dnsErr.IsNotFound:
+		return &LookupError{Status: "NXDOMAIN", Err: err}
+	case isDNSErr && dnsErr.IsTemporary:
+		return &LookupError{Status: "SERVFAIL", Err: err}
+	}
+	return &LookupError{Status: "ERROR", Err: err}
+}
 
-	for _, input := range inputs {
-		// If the input is an IP address, perform PTR lookup
-		if CheckIPv4(input) {
-			wg.Add(1)
-			go func(input string) {
-				defer wg.Done()
-				domainName, success := PTRLookup(ctx, input)
-				resultChan <- struct {
-					key     string
-					address string
-					success bool
-				}{input, domainName, success}
-			}(input)
-		} else { // Otherwise, perform DNS lookup
-			wg.Add(1)
-			go func(input string) {
-				defer wg.Done()
-				ipAddr, success := DNSLookup(ctx, input)
-				resultChan <- struct {
-					key     string
-					address string
-					success bool
-				}{input, ipAddr, success}
-			}(input)
-		}
-		keys = append(keys, input) // Track the order of the inputs
+// lookupServer fills in result with the answers of server to wire-level queries, A before AAAA like lookupSystem.
+// It returns how long the result may be cached, the shortest TTL of the answers.
+func lookupServer(ctx context.Context, result *LookupResult, server string, family AddressFamily) time.Duration {
+	qtypes := []string{"A", "AAAA"}
+	switch {
+	case result.Kind == LookupPTR:
+		qtypes = []string{"PTR"}
+	case family == FamilyIPv4:
+		qtypes = qtypes[:1]
+	case family == FamilyIPv6:
+		qtypes = qtypes[1:]
 	}
 
-	// Goroutine to close the results channel once all lookups are done
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
+	var answer DNSAnswer
+	ttl := dnsCacheMaxTTL
+	for _, qtype := range qtypes {
+		answer = QueryDNS(ctx, server, result.Input, qtype, DefaultDNSTimeout)
+		ttl = min(ttl, answerTTL(answer))
+		for _, data := range answer.Data() {
+			result.Answers = append(result.Answers, RemoveTrailingDot(data))
+		}
+		if answer.Status() != "NODATA" && len(result.Answers) == 0 {
+			// NXDOMAIN, SERVFAIL or no answer at all, asking for the other family would get the same
+			break
+		}
+	}
+	if len(result.Answers) == 0 {
+		result.Err = &LookupError{Status: answer.Status(), Err: answer.Err}
+	}
+	return ttl
+}
 
-	// Collect results from the channel
-	for res := range resultChan {
-		results[res.key] = []interface{}{res.address, res.success}
+// DNSLookup performs a DNS lookup on the provided host to get its IP address.
+// A "v4:" or "v6:" prefix on host restricts the lookup to A or AAAA records; without one
+// the IPv4 address is preferred and the IPv6 address is used for IPv6-only names.
+// It returns the resolved IP address, or the error if the lookup failed, and a boolean indicating if the lookup was successful.
+func DNSLookup(ctx context.Context, host string) (string, bool) {
+	result := Lookup(ctx, host, "")
+	if !result.OK() {
+		return result.Err.Error(), false
 	}
+	return result.Answer(), true
+}
 
-	return results, keys
+// PTRLookup performs a reverse DNS lookup (PTR) on the provided IPv4 or IPv6 address to get its associated domain name.
+// A family prefix or port on the address is ignored.
+// It returns the first associated domain name, or the error if the lookup failed, and a boolean indicating if the lookup was successful.
+func PTRLookup(ctx context.Context, ipAddr string) (string, bool) {
+	result := Lookup(ctx, ipAddr, "")
+	if !result.OK() {
+		return result.Err.Error(), false
+	}
+	return result.Answer(), true
 }
 
-// DNSPTR to IP performs DNS and PTR lookups based on the inputs provided.
+// DNSPTR performs DNS and PTR lookups based on the inputs provided.
 // If an input is an IP address, it will perform a PTR lookup. Otherwise, it does a DNS lookup.
-// It returns a map containing the results and a slice of keys (inputs) in their original order.
+// It returns the results in the order of the inputs.
+func DNSPTR(ctx context.Context, inputs []string) []LookupResult {
+	return DNSPTRVia(ctx, inputs, "")
+}
+
+// DNSPTRVia is DNSPTR with the lookups sent to server, "host:port", instead of the system resolver.
+// An empty server uses the system resolver. Lookups are made by BulkLookup with DefaultLookupOptions.
+func DNSPTRVia(ctx context.Context, inputs []string, server string) []LookupResult {
+	opts := DefaultLookupOptions()
+	opts.Server = server
+	return BulkLookup(ctx, inputs, opts)
+}
+
+// DNSPTRtoIP resolves the inputs that are not IP addresses with DNS lookups.
+// It returns the IP address of each input in the order of the inputs, or why its lookup failed.
 func DNSPTRtoIP(ctx context.Context, inputs []string) []string {
-	type result struct {
-		index   int
-		address string
-		success bool
-	}
-
-	results := make([]result, len(inputs)) // Use a slice to store results in order
-	var wg sync.WaitGroup
-	resultChan := make(chan result, len(inputs)) // Buffered channel
-
-	for i, input := range inputs {
-		wg.Add(1)
-		go func(i int, input string) {
-			defer wg.Done()
-			var res result
-			res.index = i // Capture the index
-			if CheckIPv4(input) {
-				res.address = input
-				res.success = true
-			} else {
-				res.address, res.success = DNSLookup(ctx, input)
-			}
-			resultChan <- res
-		}(i, input) // Pass the current index and input
-	}
-
-	// Goroutine to close the results channel once all lookups are done
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-
-	// Collect results
-	for res := range resultChan {
-		results[res.index] = res // Place the result in its original position
+	return DNSPTRtoIPVia(ctx, inputs, "")
+}
+
+// DNSPTRtoIPVia is DNSPTRtoIP with the DNS lookups sent to server, "host:port", instead of the system resolver.
+// An empty server uses the system resolver.
+func DNSPTRtoIPVia(ctx context.Context, inputs []string, server string) []string {
+	var names []string
+	for _, input := range inputs {
+		if !CheckIP(input) {
+			names = append(names, input)
+		}
 	}
+	results := DNSPTRVia(ctx, names, server)
 
 	// Construct the final slice of IP addresses, maintaining the original order
-	var ipAddresses []string
-	for _, res := range results {
-		if res.success {
-			ipAddresses = append(ipAddresses, res.address)
+	ipAddresses := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		if CheckIP(input) {
+			address, _ := SplitFamily(input)
+			ipAddresses = append(ipAddresses, address)
+			continue
+		}
+		result := results[0]
+		results = results[1:]
+		if result.OK() {
+			ipAddresses = append(ipAddresses, result.Answer())
 		} else {
-			// Handle failed lookups if necessary
-			ipAddresses = append(ipAddresses, "Lookup failed")
+			ipAddresses = append(ipAddresses, fmt.Sprintf("Lookup failed: %s", result.Err.Status))
 		}
 	}
 
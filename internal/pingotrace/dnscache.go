@@ -0,0 +1,172 @@
+package pingotrace
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long Lookup keeps results. Answers of a DNS server are kept for their TTL up to dnsCacheMaxTTL;
+// the system resolver does not tell TTLs, so its answers are kept for dnsCacheSystemTTL unless the name server
+// of the system gives the same answer with its TTL.
+const (
+	dnsCacheMaxTTL      = time.Hour
+	dnsCacheSystemTTL   = time.Minute
+	dnsCacheNegativeTTL = 30 * time.Second // Most NXDOMAIN and NODATA are kept, below the SOA minimum of most zones
+	dnsCacheFailureTTL  = 5 * time.Second  // Timeouts, SERVFAIL and other errors, which may clear up soon
+	dnsCacheSweep       = time.Minute      // Expired results are removed at most this often
+)
+
+// The name server of the system is asked directly for the TTLs of the answers of the system resolver.
+const (
+	systemTTLTimeout = time.Second      // Wait for its answer, the system resolver having answered already
+	systemTTLRefresh = 30 * time.Second // How long its address, or that it does not answer, is remembered
+)
+
+// systemTTLAddress is the name server of the system, found again once expires has passed.
+var systemTTLAddress struct {
+	mu      sync.Mutex
+	server  string // "" while it is not to be asked, having timed out
+	expires time.Time
+}
+
+// systemTTLServer returns the address and port of the name server of the system, or "" if it is not to be asked.
+func systemTTLServer() string {
+	systemTTLAddress.mu.Lock()
+	defer systemTTLAddress.mu.Unlock()
+	if now := time.Now(); !now.Before(systemTTLAddress.expires) {
+		systemTTLAddress.server, systemTTLAddress.expires = systemDNSServer(), now.Add(systemTTLRefresh)
+	}
+	return systemTTLAddress.server
+}
+
+// systemTTLServerFailed stops asking server, which did not answer, until the address is found again.
+func systemTTLServerFailed(server string) {
+	systemTTLAddress.mu.Lock()
+	defer systemTTLAddress.mu.Unlock()
+	if systemTTLAddress.server == server {
+		systemTTLAddress.server = ""
+	}
+}
+
+// DNSCacheStats counts the lookups answered by the cache of Lookup since it was last flushed.
+type DNSCacheStats struct {
+	Hits    int // Lookups answered from the cache
+	Misses  int // Lookups sent to a resolver
+	Entries int // Results in the cache, including expired ones not yet removed
+}
+
+// dnsCacheKey identifies a lookup: what was asked, of which resolver.
+type dnsCacheKey struct {
+	server string // "" for the system resolver
+	kind   LookupKind
+	family AddressFamily
+	host   string
+}
+
+// dnsCacheEntry is a cached lookup result.
+type dnsCacheEntry struct {
+	answers []string
+	err     *LookupError
+	expires time.Time
+}
+
+// dnsCache holds the results of Lookup, shared by every tool.
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[dnsCacheKey]dnsCacheEntry
+	stats   DNSCacheStats
+	swept   time.Time // When expired results were last removed
+}
+
+var lookupCache = &dnsCache{entries: make(map[dnsCacheKey]dnsCacheEntry)}
+
+// get returns the cached result of key if it has not expired and refresh is not set, counting a hit or a miss.
+func (c *dnsCache) get(key dnsCacheKey, refresh bool) (dnsCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if ok && !refresh && time.Now().Before(entry.expires) {
+		c.stats.Hits++
+		return entry, true
+	}
+	c.stats.Misses++
+	return dnsCacheEntry{}, false
+}
+
+// put caches a result for ttl, first removing the expired results if they were not looked for since dnsCacheSweep.
+func (c *dnsCache) put(key dnsCacheKey, answers []string, err *LookupError, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.swept) >= dnsCacheSweep {
+		for other, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, other)
+			}
+		}
+		c.swept = now
+	}
+	c.entries[key] = dnsCacheEntry{answers: answers, err: err, expires: now.Add(ttl)}
+}
+
+// FlushDNSCache forgets every cached lookup result and resets the counters, returning their values before the flush.
+func FlushDNSCache() DNSCacheStats {
+	lookupCache.mu.Lock()
+	defer lookupCache.mu.Unlock()
+	stats := lookupCache.stats
+	stats.Entries = len(lookupCache.entries)
+	lookupCache.entries = make(map[dnsCacheKey]dnsCacheEntry)
+	lookupCache.stats = DNSCacheStats{}
+	return stats
+}
+
+// DNSCacheStatistics returns the counters of the lookup cache.
+func DNSCacheStatistics() DNSCacheStats {
+	lookupCache.mu.Lock()
+	defer lookupCache.mu.Unlock()
+	stats := lookupCache.stats
+	stats.Entries = len(lookupCache.entries)
+	return stats
+}
+
+// answerTTL returns how long the outcome of a DNS answer may be cached: the lowest TTL of its records,
+// the negative caching time of its SOA for NXDOMAIN and NODATA (RFC 2308), or dnsCacheFailureTTL
+// for answers that are not a verdict on the name.
+func answerTTL(answer DNSAnswer) time.Duration {
+	switch answer.Status() {
+	case "NOERROR":
+		ttl := dnsCacheMaxTTL
+		for _, record := range answer.Records {
+			ttl = min(ttl, record.TTL)
+		}
+		return ttl
+	case "NXDOMAIN", "NODATA":
+		ttl := dnsCacheNegativeTTL
+		for _, record := range answer.Authority {
+			if record.Type == "SOA" {
+				ttl = min(ttl, record.TTL, soaMinimum(record))
+			}
+		}
+		return ttl
+	default:
+		return dnsCacheFailureTTL
+	}
+}
+
+// soaMinimum returns the last field of the data of an SOA record, the TTL of negative answers.
+func soaMinimum(record DNSRecord) time.Duration {
+	fields := strings.Fields(record.Data)
+	if len(fields) != 7 {
+		return dnsCacheNegativeTTL
+	}
+	minimum, err := strconv.ParseUint(fields[6], 10, 32)
+	if err != nil {
+		return dnsCacheNegativeTTL
+	}
+	return time.Duration(minimum) * time.Second
+}
@@ -0,0 +1,128 @@
+package pingotrace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestAnswerTTL(t *testing.T) {
+	server := startFakeDNS(t, "127.0.0.1:0", zoneHandler("example.test.", testZone...))
+	tests := []struct {
+		name, qtype string
+		want        time.Duration
+	}{
+		{"host.example.test", "A", 300 * time.Second},
+		{"www.example.test", "A", 60 * time.Second}, // Lowest TTL of the CNAME chain
+		{"missing.example.test", "A", 30 * time.Second},
+		{"example.test", "A", 30 * time.Second},
+	}
+	for _, tt := range tests {
+		answer := QueryDNS(context.Background(), server, tt.name, tt.qtype, time.Second)
+		if got := answerTTL(answer); got != tt.want {
+			t.Errorf("%s %s: TTL %v, want %v", tt.name, tt.qtype, got, tt.want)
+		}
+	}
+
+	if got := answerTTL(DNSAnswer{Err: context.DeadlineExceeded}); got != dnsCacheFailureTTL {
+		t.Errorf("timeout: TTL %v, want %v", got, dnsCacheFailureTTL)
+	}
+	soa := DNSRecord{Type: "SOA", TTL: time.Hour, Data: "ns1.example.test. hostmaster.example.test. 1 3600 600 86400 10"}
+	if got := answerTTL(DNSAnswer{RCode: DNSNXDomain, Authority: []DNSRecord{soa}}); got != 10*time.Second {
+		t.Errorf("NXDOMAIN with SOA minimum 10: TTL %v, want 10s", got)
+	}
+}
+
+func TestDNSCacheSweep(t *testing.T) {
+	cache := &dnsCache{entries: make(map[dnsCacheKey]dnsCacheEntry)}
+	expired := dnsCacheKey{host: "expired.example.test"}
+	fresh := dnsCacheKey{host: "fresh.example.test"}
+	cache.entries[expired] = dnsCacheEntry{expires: time.Now().Add(-time.Second)}
+	cache.entries[fresh] = dnsCacheEntry{expires: time.Now().Add(time.Hour)}
+
+	cache.put(dnsCacheKey{host: "new.example.test"}, []string{"192.0.2.1"}, nil, time.Minute)
+	if _, ok := cache.entries[expired]; ok {
+		t.Errorf("expired result kept after put")
+	}
+	if len(cache.entries) != 2 {
+		t.Errorf("%d results after put, want the fresh and the new one", len(cache.entries))
+	}
+
+	// Not swept again before dnsCacheSweep
+	cache.entries[expired] = dnsCacheEntry{expires: time.Now().Add(-time.Second)}
+	cache.put(dnsCacheKey{host: "other.example.test"}, nil, &LookupError{Status: "NXDOMAIN"}, time.Minute)
+	if _, ok := cache.entries[expired]; !ok {
+		t.Errorf("expired result removed twice within %v", dnsCacheSweep)
+	}
+	if entry, ok := cache.get(expired, false); ok {
+		t.Errorf("expired result %v returned by get", entry)
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	server := startFakeDNS(t, "127.0.0.1:0", zoneHandler("example.test.", testZone...))
+	FlushDNSCache()
+	defer FlushDNSCache()
+
+	first := Lookup(context.Background(), "host.example.test", server)
+	second := Lookup(context.Background(), "HOST.example.test", server)
+	if first.Cached || !second.Cached || second.Answer() != first.Answer() || first.Answer() != "192.0.2.1" {
+		t.Errorf("lookups: %v cached %v, then %v cached %v, want the second from the cache", first, first.Cached, second, second.Cached)
+	}
+	if stats := DNSCacheStatistics(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("statistics %+v, want 1 hit, 1 miss and 1 entry", stats)
+	}
+	if stats := FlushDNSCache(); stats.Entries != 1 {
+		t.Errorf("flush: %+v, want the statistics before the flush", stats)
+	}
+	if stats := DNSCacheStatistics(); stats != (DNSCacheStats{}) {
+		t.Errorf("statistics after flush %+v, want none", stats)
+	}
+}
+
+func TestSystemAnswerTTL(t *testing.T) {
+	// The system resolver finds localhost in the hosts file, the fake name servers of the system tell TTLs
+	same := startFakeDNS(t, "127.0.0.1:0", zoneHandler("localhost.", rrA("localhost.", "127.0.0.1", 300)))
+	other := startFakeDNS(t, "127.0.0.1:0", zoneHandler("localhost.", rrA("localhost.", "192.0.2.1", 300)))
+	silent := startFakeDNS(t, "127.0.0.1:0", func(dnsmessage.Question, bool) fakeReply {
+		return fakeReply{drop: true}
+	})
+	defer func() {
+		systemTTLAddress.mu.Lock()
+		systemTTLAddress.expires = time.Time{}
+		systemTTLAddress.mu.Unlock()
+		FlushDNSCache()
+	}()
+
+	tests := []struct {
+		server string
+		want   time.Duration
+		asked  bool // The server is still asked afterwards
+	}{
+		{same, 300 * time.Second, true},
+		{other, dnsCacheSystemTTL, true},
+		{silent, dnsCacheSystemTTL, false},
+	}
+	for _, tt := range tests {
+		systemTTLAddress.mu.Lock()
+		systemTTLAddress.server, systemTTLAddress.expires = tt.server, time.Now().Add(time.Hour)
+		systemTTLAddress.mu.Unlock()
+		FlushDNSCache()
+
+		result := Lookup(context.Background(), "v4:localhost", "")
+		if !result.OK() || result.Answer() != "127.0.0.1" {
+			t.Fatalf("%s: got %v, want the address of the hosts file", tt.server, result)
+		}
+		lookupCache.mu.Lock()
+		entry := lookupCache.entries[dnsCacheKey{family: FamilyIPv4, host: "localhost"}]
+		lookupCache.mu.Unlock()
+		if ttl := time.Until(entry.expires); ttl > tt.want || ttl < tt.want-5*time.Second {
+			t.Errorf("%s: cached for %v, want %v", tt.server, ttl.Round(time.Second), tt.want)
+		}
+		if asked := systemTTLServer() != ""; asked != tt.asked {
+			t.Errorf("%s: asked again %v, want %v", tt.server, asked, tt.asked)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package pingotrace
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ParseDNSServers parses a list of DNS servers separated by commas or spaces, each an IP address
+// with an optional port (53 by default), a "host:port", "system" for the system resolver, a DNS over TLS
+// server as "tls://host" with an optional port (853 by default), or the https URL of a DNS over HTTPS server.
+// It returns them as "host:port", "tls://host:port" or the URL, with "" standing for the system resolver;
+// an empty list means the system resolver alone.
+func ParseDNSServers(text string) ([]string, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n'
+	})
+	if len(fields) == 0 {
+		return []string{""}, nil
+	}
+
+	var servers []string
+	for _, field := range fields {
+		server := ""
+		switch host, port, err := net.SplitHostPort(field); {
+		case strings.EqualFold(field, "system"):
+		case strings.HasPrefix(strings.ToLower(field), "tls://"):
+			address := field[len("tls://"):]
+			if host, port, err := net.SplitHostPort(address); err == nil && host != "" && port != "" {
+				server = "tls://" + address
+			} else if address = strings.Trim(address, "[]"); address != "" && !strings.ContainsAny(address, "/[]") {
+				server = "tls://" + net.JoinHostPort(address, DefaultDoTPort)
+			} else {
+				return nil, fmt.Errorf("invalid DNS over TLS server %q, expected tls://host or tls://host:port", field)
+			}
+		case strings.HasPrefix(strings.ToLower(field), "https://"):
+			u, err := url.Parse(field)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("invalid DNS over HTTPS server %q, expected a URL such as https://dns.example/dns-query", field)
+			}
+			u.Scheme = "https"
+			server = u.String()
+		case net.ParseIP(strings.Trim(field, "[]")) != nil:
+			server = net.JoinHostPort(strings.Trim(field, "[]"), "53")
+		case err == nil && host != "" && port != "":
+			server = field
+		default:
+			return nil, fmt.Errorf("invalid DNS server %q, expected an IP address, host:port, tls://host, an https URL or system", field)
+		}
+		if !slices.Contains(servers, server) {
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+// DNSServerName returns how server, as returned by ParseDNSServers, is shown: "system" for the system resolver.
+func DNSServerName(server string) string {
+	if server == "" {
+		return "system"
+	}
+	return server
+}
+
+// DNSComparison is the answer of every server compared to the same query.
+type DNSComparison struct {
+	Input   string      // Input as given
+	Type    string      // Record type asked for: PTR for IP addresses, AAAA for "v6:" names, A otherwise
+	Servers []string    // Servers asked, the first one being the reference
+	Answers []DNSAnswer // Answer of each server, in the order of Servers
+}
+
+// Agrees reports whether server i gave the same answer as the reference server: the same status
+// and the same set of records of the type asked for, regardless of order and TTL.
+func (c DNSComparison) Agrees(i int) bool {
+	return answerKey(c.Answers[i]) == answerKey(c.Answers[0])
+}
+
+// Differs reports whether any server disagrees with the reference server.
+func (c DNSComparison) Differs() bool {
+	for i := range c.Answers {
+		if !c.Agrees(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// answerKey returns what answers are compared by, the status and the sorted data of the records.
+func answerKey(answer DNSAnswer) string {
+	data := answer.Data()
+	slices.Sort(data)
+	return answer.Status() + " " + strings.Join(data, ",")
+}
+
+// CompareDNS sends the same query for each input to every server, DefaultParallel queries at once, and returns
+// the answers side by side, in the order of inputs. Servers are "host:port", or "" for the system resolver as with QueryDNS.
+func CompareDNS(ctx context.Context, inputs, servers []string, timeout time.Duration) []DNSComparison {
+	comparisons := make([]DNSComparison, len(inputs))
+	for i, input := range inputs {
+		qtype := "A"
+		target, _ := SplitPort(input)
+		if _, family := SplitFamily(target); CheckIP(input) {
+			qtype = "PTR"
+		} else if family == FamilyIPv6 {
+			qtype = "AAAA"
+		}
+		comparisons[i] = DNSComparison{Input: input, Type: qtype, Servers: servers, Answers: make([]DNSAnswer, len(servers))}
+	}
+
+	// One query per input and server
+	ForEachParallel(len(inputs)*len(servers), DefaultParallel, func(k int) {
+		comparison := &comparisons[k/len(servers)]
+		comparison.Answers[k%len(servers)] = QueryDNS(ctx, servers[k%len(servers)], comparison.Input, comparison.Type, timeout)
+	})
+	return comparisons
+}
@@ -0,0 +1,97 @@
+package pingotrace
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseDNSServers(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{""}},
+		{"system", []string{""}},
+		{"1.1.1.1", []string{"1.1.1.1:53"}},
+		{"1.1.1.1:5353", []string{"1.1.1.1:5353"}},
+		{"2001:db8::53, [2001:db8::54]:5353", []string{"[2001:db8::53]:53", "[2001:db8::54]:5353"}},
+		{"dns.example:53", []string{"dns.example:53"}},
+		{"tls://1.1.1.1", []string{"tls://1.1.1.1:853"}},
+		{"TLS://dns.example:8853", []string{"tls://dns.example:8853"}},
+		{"tls://[2001:db8::53]", []string{"tls://[2001:db8::53]:853"}},
+		{"HTTPS://dns.example/dns-query", []string{"https://dns.example/dns-query"}},
+		{"1.1.1.1; system 1.1.1.1,8.8.8.8", []string{"1.1.1.1:53", "", "8.8.8.8:53"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseDNSServers(tt.text)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.text, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	for _, text := range []string{"dns.example", "1.1.1.1:", "tls://", "tls://dns.example/path", "https://", "https:///dns-query"} {
+		if got, err := ParseDNSServers(text); err == nil {
+			t.Errorf("%q: got %q, want an error", text, got)
+		}
+	}
+}
+
+func TestCompareDNS(t *testing.T) {
+	reference := startFakeDNS(t, "127.0.0.1:0", zoneHandler("example.test.", testZone...))
+	same := startFakeDNS(t, "127.0.0.1:0", zoneHandler("example.test.", testZone...))
+	other := startFakeDNS(t, "127.0.0.1:0", zoneHandler("example.test.",
+		rrA("host.example.test.", "192.0.2.99", 300),
+		rrPTR("1.2.0.192.in-addr.arpa.", "host.example.test.", 30), // Same data, other TTL
+	))
+	silent := startFakeDNS(t, "127.0.0.1:0", func(dnsmessage.Question, bool) fakeReply {
+		return fakeReply{drop: true}
+	})
+	servers := []string{reference, same, other, silent}
+
+	inputs := []string{"host.example.test", "v6:host.example.test", "192.0.2.1", "missing.example.test"}
+	comparisons := CompareDNS(context.Background(), inputs, servers, 300*time.Millisecond)
+	if len(comparisons) != len(inputs) {
+		t.Fatalf("got %d comparisons, want %d", len(comparisons), len(inputs))
+	}
+
+	tests := []struct {
+		qtype    string
+		statuses []string
+		agrees   []bool
+	}{
+		{"A", []string{"NOERROR", "NOERROR", "NOERROR", "TIMEOUT"}, []bool{true, true, false, false}},
+		{"AAAA", []string{"NOERROR", "NOERROR", "NODATA", "TIMEOUT"}, []bool{true, true, false, false}},
+		{"PTR", []string{"NOERROR", "NOERROR", "NOERROR", "TIMEOUT"}, []bool{true, true, true, false}},
+		{"A", []string{"NXDOMAIN", "NXDOMAIN", "NXDOMAIN", "TIMEOUT"}, []bool{true, true, true, false}},
+	}
+	for i, tt := range tests {
+		comparison := comparisons[i]
+		if comparison.Input != inputs[i] || comparison.Type != tt.qtype {
+			t.Errorf("%s: input %q type %s, want type %s", inputs[i], comparison.Input, comparison.Type, tt.qtype)
+		}
+		for j, answer := range comparison.Answers {
+			if status := answer.Status(); status != tt.statuses[j] {
+				t.Errorf("%s from %s: status %s, want %s", inputs[i], servers[j], status, tt.statuses[j])
+			}
+			if agrees := comparison.Agrees(j); agrees != tt.agrees[j] {
+				t.Errorf("%s from %s: agrees %v, want %v", inputs[i], servers[j], agrees, tt.agrees[j])
+			}
+		}
+		if !comparison.Differs() {
+			t.Errorf("%s: no difference, want the silent server to differ", inputs[i])
+		}
+	}
+
+	agreeing := CompareDNS(context.Background(), []string{"www.example.test"}, []string{reference, same}, time.Second)
+	if agreeing[0].Differs() {
+		t.Errorf("www.example.test: servers with the same zone differ: %v", agreeing[0].Answers)
+	}
+}
@@ -0,0 +1,94 @@
+package pingotrace
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultDoTPort is the port of DNS over TLS servers given without one (RFC 7858).
+const DefaultDoTPort = "853"
+
+// Media type of DNS messages over HTTPS (RFC 8484)
+const dnsMessageType = "application/dns-message"
+
+// encryptedDNSTLS is the TLS configuration of DNS over TLS and DNS over HTTPS connections.
+// Certificates are verified against the roots of the system.
+var encryptedDNSTLS = &tls.Config{MinVersion: tls.VersionTLS12}
+
+// dohClient sends DNS over HTTPS queries, keeping connections to the servers open between queries.
+var dohClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     encryptedDNSTLS,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     30 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// isDoT reports whether server is a DNS over TLS server, "tls://host:port".
+func isDoT(server string) bool {
+	return strings.HasPrefix(server, "tls://")
+}
+
+// isDoH reports whether server is the URL of a DNS over HTTPS server.
+func isDoH(server string) bool {
+	return strings.HasPrefix(server, "https://")
+}
+
+// dialDoT opens a TLS connection to a DNS over TLS server, checking that its certificate is valid for its host.
+func dialDoT(ctx context.Context, server string) (net.Conn, error) {
+	address := strings.TrimPrefix(server, "tls://")
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	config := encryptedDNSTLS.Clone()
+	config.ServerName = host
+	dialer := tls.Dialer{Config: config}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// exchangeDoH posts query to the DNS over HTTPS server at url and returns the DNS message of the response.
+func exchangeDoH(ctx context.Context, url string, query []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", dnsMessageType)
+	request.Header.Set("Accept", dnsMessageType)
+	request.Header.Set("User-Agent", "PinGoTrace")
+
+	response, err := dohClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server answered HTTP %s", response.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type")); mediaType != dnsMessageType {
+		return nil, fmt.Errorf("server answered %q instead of %s", response.Header.Get("Content-Type"), dnsMessageType)
+	}
+	// A DNS message is at most 65535 bytes long
+	body, err := io.ReadAll(io.LimitReader(response.Body, 65536))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 65535 {
+		return nil, errors.New("answer is longer than a DNS message")
+	}
+	return body, nil
+}
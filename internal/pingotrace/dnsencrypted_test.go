@@ -0,0 +1,132 @@
+package pingotrace
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var trustTestCertificateOnce sync.Once
+
+// trustTestCertificate makes encrypted DNS trust the certificate of httptest servers, which is the same for all of them.
+// It must be called before the first encrypted query.
+func trustTestCertificate(certificate *x509.Certificate) {
+	trustTestCertificateOnce.Do(func() {
+		pool := x509.NewCertPool()
+		pool.AddCert(certificate)
+		encryptedDNSTLS.RootCAs = pool
+	})
+}
+
+// startDoH starts a DNS over HTTPS server answering from handler at /dns-query, and counts the queries whose ID is not 0.
+func startDoH(t *testing.T, handler fakeHandler, nonZeroIDs *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dns-query":
+		case "/text":
+			w.Write([]byte("not a DNS message"))
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		query, err := io.ReadAll(r.Body)
+		if err != nil || r.Method != http.MethodPost || r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if len(query) >= 2 && (query[0] != 0 || query[1] != 0) {
+			nonZeroIDs.Add(1)
+		}
+		response, _ := fakeResponse(query, handler, true)
+		w.Header().Set("Content-Type", dnsMessageType)
+		w.Write(response)
+	}))
+	server.EnableHTTP2 = true
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	trustTestCertificate(server.Certificate())
+	return server
+}
+
+func TestDoH(t *testing.T) {
+	var nonZeroIDs atomic.Int32
+	server := startDoH(t, zoneHandler("example.test.", testZone...), &nonZeroIDs)
+	ctx := context.Background()
+
+	answer := QueryDNS(ctx, server.URL+"/dns-query", "www.example.test", "A", time.Second)
+	if answer.Err != nil || !slices.Equal(answer.Data(), []string{"192.0.2.1"}) {
+		t.Errorf("DoH: data %q, error %v, want 192.0.2.1", answer.Data(), answer.Err)
+	}
+	answer = QueryDNS(ctx, server.URL+"/dns-query", "missing.example.test", "A", time.Second)
+	if answer.Status() != "NXDOMAIN" {
+		t.Errorf("DoH: status %s, error %v, want NXDOMAIN", answer.Status(), answer.Err)
+	}
+	if n := nonZeroIDs.Load(); n != 0 {
+		t.Errorf("DoH: %d queries with an ID other than 0", n)
+	}
+
+	for _, path := range []string{"/missing", "/text"} {
+		answer := QueryDNS(ctx, server.URL+path, "host.example.test", "A", time.Second)
+		if answer.Status() != "ERROR" {
+			t.Errorf("DoH at %s: status %s, error %v, want ERROR", path, answer.Status(), answer.Err)
+		}
+	}
+}
+
+func TestDoT(t *testing.T) {
+	// The certificate of an httptest server is valid for 127.0.0.1 but not for localhost
+	certificates := startDoH(t, zoneHandler("example.test."), new(atomic.Int32)).TLS.Certificates
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveFakeStream(listener, zoneHandler("example.test.", testZone...))
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	ctx := context.Background()
+
+	servers, err := ParseDNSServers("tls://127.0.0.1:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := QueryDNS(ctx, servers[0], "host.example.test", "AAAA", time.Second)
+	if answer.Err != nil || !slices.Equal(answer.Data(), []string{"2001:db8::1"}) {
+		t.Errorf("DoT: data %q, error %v, want 2001:db8::1", answer.Data(), answer.Err)
+	}
+
+	answer = QueryDNS(ctx, "tls://localhost:"+port, "host.example.test", "A", time.Second)
+	if answer.Status() != "ERROR" || !strings.Contains(answer.Err.Error(), "certificate") {
+		t.Errorf("DoT with the wrong host name: status %s, error %v, want a certificate error", answer.Status(), answer.Err)
+	}
+
+	// A plain DNS server does not speak TLS
+	plain := startFakeDNS(t, "127.0.0.1:0", zoneHandler("example.test.", testZone...))
+	answer = QueryDNS(ctx, "tls://"+plain, "host.example.test", "A", 500*time.Millisecond)
+	if answer.Err == nil {
+		t.Errorf("DoT to a plain DNS server: status %s, want an error", answer.Status())
+	}
+}
+
+func TestDoHWrongHost(t *testing.T) {
+	// A server whose certificate is valid but for another name than the one in the URL
+	server := startDoH(t, zoneHandler("example.test.", testZone...), new(atomic.Int32))
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+	answer := QueryDNS(context.Background(), "https://localhost:"+port+"/dns-query", "host.example.test", "A", time.Second)
+	if answer.Status() != "ERROR" || !strings.Contains(answer.Err.Error(), "certificate") {
+		t.Errorf("DoH with the wrong host name: status %s, error %v, want a certificate error", answer.Status(), answer.Err)
+	}
+}
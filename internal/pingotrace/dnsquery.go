@@ -0,0 +1,458 @@
+package pingotrace
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSTypes are the record types DNS queries can ask for, in the order QueryDNSAll asks for them.
+var DNSTypes = []string{"A", "AAAA", "CNAME", "MX", "NS", "TXT", "SOA", "SRV", "CAA", "PTR"}
+
+// DefaultDNSTimeout is the time allowed for a DNS query, including a retry over TCP.
+const DefaultDNSTimeout = 3 * time.Second
+
+const (
+	typeCAA    dnsmessage.Type = 257  // Certification Authority Authorization (RFC 8659), unknown to dnsmessage
+	dnsUDPSize                 = 1232 // EDNS0 UDP payload size that avoids IP fragmentation
+)
+
+// dnsTypeCodes maps the names of DNSTypes to their type codes.
+var dnsTypeCodes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"TXT":   dnsmessage.TypeTXT,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"CAA":   typeCAA,
+	"PTR":   dnsmessage.TypePTR,
+}
+
+// dnsTypeName returns the name of a record type, or "TYPE65" style for types without one (RFC 3597).
+func dnsTypeName(t dnsmessage.Type) string {
+	for name, code := range dnsTypeCodes {
+		if code == t {
+			return name
+		}
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+// DNSRCode is the response code of a DNS answer.
+type DNSRCode int
+
+// Response codes of RFC 1035 and RFC 2136
+const (
+	DNSNoError  DNSRCode = 0
+	DNSFormErr  DNSRCode = 1
+	DNSServFail DNSRCode = 2
+	DNSNXDomain DNSRCode = 3
+	DNSNotImp   DNSRCode = 4
+	DNSRefused  DNSRCode = 5
+)
+
+// String returns the mnemonic of the code as dig shows it, e.g. "NXDOMAIN".
+func (c DNSRCode) String() string {
+	switch c {
+	case DNSNoError:
+		return "NOERROR"
+	case DNSFormErr:
+		return "FORMERR"
+	case DNSServFail:
+		return "SERVFAIL"
+	case DNSNXDomain:
+		return "NXDOMAIN"
+	case DNSNotImp:
+		return "NOTIMP"
+	case DNSRefused:
+		return "REFUSED"
+	default:
+		return fmt.Sprintf("RCODE%d", int(c))
+	}
+}
+
+// DNSRecord is one resource record of a DNS answer.
+type DNSRecord struct {
+	Name string // Owner name, fully qualified with the trailing dot
+	Type string // Record type, e.g. "MX"
+	TTL  time.Duration
+	Data string // Record data in zone file format, e.g. "10 mail.example.com."
+}
+
+// String formats the record as a zone file line, e.g. "example.com.  300  IN  MX  10 mail.example.com.".
+func (r DNSRecord) String() string {
+	return fmt.Sprintf("%-35s %7d  IN  %-5s  %s", r.Name, int(r.TTL.Seconds()), r.Type, r.Data)
+}
+
+// DNSAnswer is the outcome of one DNS query.
+type DNSAnswer struct {
+	Name          string   // Name queried, fully qualified
+	Type          string   // Record type queried
+	Server        string   // Address and port of the server queried
+	RCode         DNSRCode // Response code, meaningful only when Err is nil
+	Authoritative bool     // The server is authoritative for Name
+	TCP           bool     // The answer over UDP was truncated and the query was repeated over TCP
+
+	Records    []DNSRecord // Answer section, CNAME records included, in the order received
+	Authority  []DNSRecord // Authority section, such as the SOA that sets how long a negative answer is cached
+	Additional []DNSRecord // Additional section, such as the addresses (glue) of the name servers of a referral
+	CNAMEChain []string    // Names from Name to its canonical name when Name is an alias
+	RTT        time.Duration
+	Err        error // Why no answer arrived, such as a timeout
+}
+
+// Status returns the outcome as one word: the response code, "NODATA" for a NOERROR answer without
+// records of the type queried, "TIMEOUT" when the server did not answer in time, or "ERROR".
+func (a DNSAnswer) Status() string {
+	var netErr net.Error
+	switch {
+	case a.Err == nil && a.RCode == DNSNoError && !a.hasType():
+		return "NODATA"
+	case a.Err == nil:
+		return a.RCode.String()
+	case errors.Is(a.Err, context.DeadlineExceeded) || errors.As(a.Err, &netErr) && netErr.Timeout():
+		return "TIMEOUT"
+	default:
+		return "ERROR"
+	}
+}
+
+// hasType reports whether the answer holds a record of the type queried.
+func (a DNSAnswer) hasType() bool {
+	return len(a.Data()) > 0
+}
+
+// Data returns the data of the records of the type queried, such as the addresses of an A query,
+// in the order received, leaving out the CNAME records that led to them.
+func (a DNSAnswer) Data() []string {
+	var data []string
+	for _, record := range a.Records {
+		if record.Type == a.Type {
+			data = append(data, record.Data)
+		}
+	}
+	return data
+}
+
+// QueryDNS sends a recursive query for records of type qtype (one of DNSTypes) of name to server,
+// "host:port" or "" for the first name server of this system, and returns every record of the answer.
+// Server may also be a DNS over TLS server, "tls://host:port", or the URL of a DNS over HTTPS server.
+// A PTR query for an IP address asks for its reverse name; a family prefix or port on name is ignored.
+// Answers truncated over UDP are asked again over TCP.
+func QueryDNS(ctx context.Context, server, name, qtype string, timeout time.Duration) DNSAnswer {
+	return queryDNS(ctx, server, name, qtype, timeout, true)
+}
+
+// queryDNS is QueryDNS, with the query marked as recursive or not.
+func queryDNS(ctx context.Context, server, name, qtype string, timeout time.Duration, recursive bool) DNSAnswer {
+	if server == "" {
+		server = systemDNSServer()
+	}
+	answer := DNSAnswer{Name: queryName(name, qtype), Type: strings.ToUpper(qtype), Server: server}
+	code, ok := dnsTypeCodes[answer.Type]
+	if !ok {
+		answer.Err = fmt.Errorf("unsupported record type %q", qtype)
+		return answer
+	}
+	qname, err := dnsmessage.NewName(answer.Name)
+	if err != nil {
+		answer.Err = fmt.Errorf("invalid name %q: %w", answer.Name, err)
+		return answer
+	}
+	question := dnsmessage.Question{Name: qname, Type: code, Class: dnsmessage.ClassINET}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	startTime := time.Now()
+	header, parser, err := exchangeDNS(ctx, server, question, false, recursive)
+	if err == nil && header.Truncated {
+		answer.TCP = true
+		header, parser, err = exchangeDNS(ctx, server, question, true, recursive)
+	}
+	answer.RTT = time.Since(startTime)
+	if err != nil {
+		answer.Err = err
+		return answer
+	}
+
+	answer.RCode = DNSRCode(header.RCode)
+	answer.Authoritative = header.Authoritative
+	answers, err := parser.AllAnswers()
+	if err != nil {
+		answer.Err = fmt.Errorf("malformed answer section: %w", err)
+		return answer
+	}
+	// Only informative, a malformed section loses nothing asked for
+	authorities, _ := parser.AllAuthorities()
+	additionals, _ := parser.AllAdditionals()
+	answer.Records = dnsRecords(answers)
+	answer.Authority = dnsRecords(authorities)
+	answer.Additional = dnsRecords(additionals)
+	answer.CNAMEChain = cnameChain(answer.Name, answer.Records)
+	return answer
+}
+
+// QueryDNSAll queries server for every type of DNSTypes at once and returns the answers in that order.
+// An IP address is only asked for its PTR record.
+func QueryDNSAll(ctx context.Context, server, name string, timeout time.Duration) []DNSAnswer {
+	types := DNSTypes[:len(DNSTypes)-1]
+	if CheckIP(name) {
+		types = []string{"PTR"}
+	}
+	answers := make([]DNSAnswer, len(types))
+	ForEachParallel(len(types), DefaultParallel, func(i int) {
+		answers[i] = QueryDNS(ctx, server, name, types[i], timeout)
+	})
+	return answers
+}
+
+// queryName returns the fully qualified name to ask for: the reverse name of an IP address for PTR
+// queries, otherwise name without family prefix, port or URL parts.
+func queryName(name, qtype string) string {
+	host, _ := SplitPort(name)
+	host, _ = SplitFamily(host)
+	host = strings.Trim(host, "[]")
+	if ip := net.ParseIP(host); ip != nil && strings.EqualFold(qtype, "PTR") {
+		return reverseName(ip)
+	}
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+	return host
+}
+
+// reverseName returns the name of the PTR record of ip under in-addr.arpa or ip6.arpa.
+func reverseName(ip net.IP) string {
+	var b strings.Builder
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(ip4[i])) + ".")
+		}
+		return b.String() + "in-addr.arpa."
+	}
+	const hexDigits = "0123456789abcdef"
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[ip[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	return b.String() + "ip6.arpa."
+}
+
+// exchangeDNS sends a query with question to server over UDP, or over TCP if tcp is set,
+// and returns the header of the answer with a parser positioned at its question section.
+// The server is asked to recurse if recursive is set.
+// A "tls://" server is asked over DNS over TLS and an "https://" server over DNS over HTTPS, whatever tcp.
+// Over UDP, datagrams that do not answer the query are ignored.
+func exchangeDNS(ctx context.Context, server string, question dnsmessage.Question, tcp, recursive bool) (dnsmessage.Header, *dnsmessage.Parser, error) {
+	id := uint16(rand.Uint32())
+	if isDoH(server) {
+		id = 0 // RFC 8484 asks for ID 0 so that HTTP caches can serve the same query twice
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: recursive})
+	builder.EnableCompression()
+	builder.StartQuestions()
+	builder.Question(question)
+	builder.StartAdditionals()
+	var opt dnsmessage.ResourceHeader
+	opt.SetEDNS0(dnsUDPSize, dnsmessage.RCodeSuccess, false)
+	builder.OPTResource(opt, dnsmessage.OPTResource{})
+	query, err := builder.Finish()
+	if err != nil {
+		return dnsmessage.Header{}, nil, fmt.Errorf("unable to build query: %w", err)
+	}
+
+	if isDoH(server) {
+		response, err := exchangeDoH(ctx, server, query)
+		if err != nil {
+			return dnsmessage.Header{}, nil, err
+		}
+		if header, parser, ok := parseAnswer(response, id, question); ok {
+			return header, parser, nil
+		}
+		return dnsmessage.Header{}, nil, errors.New("server answered a different query")
+	}
+
+	var conn net.Conn
+	stream := tcp || isDoT(server)
+	if isDoT(server) {
+		conn, err = dialDoT(ctx, server)
+	} else {
+		network := "udp"
+		if tcp {
+			network = "tcp"
+		}
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, network, server)
+	}
+	if err != nil {
+		return dnsmessage.Header{}, nil, err
+	}
+	defer conn.Close()
+	if stream {
+		// Over TCP every message is preceded by its length
+		query = append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblock the read when ctx is cancelled before its deadline
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.Write(query); err != nil {
+		return dnsmessage.Header{}, nil, err
+	}
+
+	for {
+		var response []byte
+		if stream {
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return dnsmessage.Header{}, nil, err
+			}
+			response = make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, response); err != nil {
+				return dnsmessage.Header{}, nil, err
+			}
+		} else {
+			buf := make([]byte, 65535)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return dnsmessage.Header{}, nil, err
+			}
+			response = buf[:n]
+		}
+
+		if header, parser, ok := parseAnswer(response, id, question); ok {
+			return header, parser, nil
+		}
+		if stream {
+			// A stream carries nothing else, this is the server's answer
+			return dnsmessage.Header{}, nil, errors.New("server answered a different query")
+		}
+	}
+}
+
+// parseAnswer parses the header of response and reports whether it is the answer to the query with id and question.
+func parseAnswer(response []byte, id uint16, question dnsmessage.Question) (dnsmessage.Header, *dnsmessage.Parser, bool) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil || header.ID != id || !header.Response || !answersQuestion(&parser, question) {
+		return dnsmessage.Header{}, nil, false
+	}
+	return header, &parser, true
+}
+
+// answersQuestion reads the question section of an answer and reports whether it repeats question.
+func answersQuestion(parser *dnsmessage.Parser, question dnsmessage.Question) bool {
+	questions, err := parser.AllQuestions()
+	if err != nil || len(questions) != 1 {
+		return false
+	}
+	q := questions[0]
+	// Servers may answer in a different letter case (DNS 0x20)
+	return q.Type == question.Type && q.Class == question.Class && strings.EqualFold(q.Name.String(), question.Name.String())
+}
+
+// dnsRecords converts resource records to DNSRecords, leaving out EDNS0 pseudo-records.
+func dnsRecords(resources []dnsmessage.Resource) []DNSRecord {
+	var records []DNSRecord
+	for _, resource := range resources {
+		if resource.Header.Type == dnsmessage.TypeOPT {
+			continue
+		}
+		records = append(records, DNSRecord{
+			Name: resource.Header.Name.String(),
+			Type: dnsTypeName(resource.Header.Type),
+			TTL:  time.Duration(resource.Header.TTL) * time.Second,
+			Data: recordData(resource.Body),
+		})
+	}
+	return records
+}
+
+// recordData formats the data of a resource record as in a zone file.
+func recordData(body dnsmessage.ResourceBody) string {
+	switch b := body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return b.CNAME.String()
+	case *dnsmessage.NSResource:
+		return b.NS.String()
+	case *dnsmessage.PTRResource:
+		return b.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", b.Pref, b.MX)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", b.Priority, b.Weight, b.Port, b.Target)
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", b.NS, b.MBox, b.Serial, b.Refresh, b.Retry, b.Expire, b.MinTTL)
+	case *dnsmessage.TXTResource:
+		quoted := make([]string, len(b.TXT))
+		for i, txt := range b.TXT {
+			quoted[i] = strconv.Quote(txt)
+		}
+		return strings.Join(quoted, " ")
+	case *dnsmessage.UnknownResource:
+		if b.Type == typeCAA {
+			if data, ok := caaData(b.Data); ok {
+				return data
+			}
+		}
+		// Generic format of RFC 3597
+		return fmt.Sprintf("\\# %d %s", len(b.Data), hex.EncodeToString(b.Data))
+	default:
+		return body.GoString()
+	}
+}
+
+// caaData formats the data of a CAA record as "flags tag \"value\"", e.g. 0 issue "letsencrypt.org".
+func caaData(data []byte) (string, bool) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return "", false
+	}
+	flags, tagLength := data[0], int(data[1])
+	tag, value := data[2:2+tagLength], data[2+tagLength:]
+	return fmt.Sprintf("%d %s %s", flags, tag, strconv.Quote(string(value))), true
+}
+
+// cnameChain follows the CNAME records of an answer from name and returns every name on the way,
+// name first and the canonical name last, or nil if name is not an alias.
+func cnameChain(name string, records []DNSRecord) []string {
+	chain := []string{name}
+	for len(chain) <= len(records) {
+		next := ""
+		for _, record := range records {
+			if record.Type == "CNAME" && strings.EqualFold(record.Name, chain[len(chain)-1]) {
+				next = record.Data
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		chain = append(chain, next)
+	}
+	if len(chain) == 1 {
+		return nil
+	}
+	return chain
+}
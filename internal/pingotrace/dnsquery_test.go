@@ -0,0 +1,368 @@
+package pingotrace
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeReply is what a fake DNS server answers to one query.
+type fakeReply struct {
+	rcode         dnsmessage.RCode
+	authoritative bool
+	answers       []dnsmessage.Resource
+	authority     []dnsmessage.Resource
+	additional    []dnsmessage.Resource
+	truncated     bool // Set TC and leave the sections out
+	drop          bool // Do not answer at all
+	stray         bool // Send an answer with another ID first, over UDP
+}
+
+// fakeHandler answers a query received over UDP, or over a stream if stream is set.
+type fakeHandler func(q dnsmessage.Question, stream bool) fakeReply
+
+// startFakeDNS starts a DNS server on address, UDP and TCP on the same port, and returns its "host:port".
+// A zero port picks a free one.
+func startFakeDNS(t *testing.T, address string, handler fakeHandler) string {
+	t.Helper()
+	for attempt := 0; ; attempt++ {
+		packetConn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+		if err != nil {
+			// The free UDP port is taken over TCP, try another
+			packetConn.Close()
+			if attempt < 10 {
+				continue
+			}
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			packetConn.Close()
+			listener.Close()
+		})
+		go serveFakeUDP(packetConn, handler)
+		go serveFakeStream(listener, handler)
+		return packetConn.LocalAddr().String()
+	}
+}
+
+func serveFakeUDP(conn net.PacketConn, handler fakeHandler) {
+	buf := make([]byte, 65535)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			response, reply := fakeResponse(query, handler, false)
+			if response == nil {
+				return
+			}
+			if reply.stray {
+				stray := append([]byte(nil), response...)
+				stray[0]++ // Another ID
+				conn.WriteTo(stray, peer)
+			}
+			conn.WriteTo(response, peer)
+		}()
+	}
+}
+
+// serveFakeStream serves queries over TCP, or over TLS when listener is a TLS listener.
+func serveFakeStream(listener net.Listener, handler fakeHandler) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				response, _ := fakeResponse(query, handler, true)
+				if response == nil {
+					return
+				}
+				conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...))
+			}
+		}()
+	}
+}
+
+// fakeResponse packs the answer of handler to query, or returns nil if it is to be dropped.
+func fakeResponse(query []byte, handler fakeHandler, stream bool) ([]byte, fakeReply) {
+	var request dnsmessage.Message
+	if err := request.Unpack(query); err != nil || len(request.Questions) != 1 {
+		return nil, fakeReply{}
+	}
+	q := request.Questions[0]
+	reply := handler(q, stream)
+	if reply.drop {
+		return nil, reply
+	}
+	response := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID: request.ID, Response: true, RCode: reply.rcode, Authoritative: reply.authoritative,
+			RecursionDesired: request.RecursionDesired, Truncated: reply.truncated,
+		},
+		Questions: request.Questions,
+	}
+	if !reply.truncated {
+		response.Answers, response.Authorities, response.Additionals = reply.answers, reply.authority, reply.additional
+	}
+	packed, err := response.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return packed, reply
+}
+
+// zoneHandler answers from records like an authoritative server: the records of the name and type
+// asked for, following CNAMEs, or NXDOMAIN or NODATA with the SOA of zone.
+func zoneHandler(zone string, records ...dnsmessage.Resource) fakeHandler {
+	return func(q dnsmessage.Question, stream bool) fakeReply {
+		reply := fakeReply{authoritative: true}
+		name := q.Name.String()
+		for range len(records) + 1 { // Bounds the CNAME chain
+			var cname string
+			exists := false
+			for _, record := range records {
+				if !strings.EqualFold(record.Header.Name.String(), name) {
+					continue
+				}
+				exists = true
+				switch body := record.Body.(type) {
+				case *dnsmessage.CNAMEResource:
+					if q.Type != dnsmessage.TypeCNAME {
+						reply.answers = append(reply.answers, record)
+						cname = body.CNAME.String()
+						continue
+					}
+				}
+				if record.Header.Type == q.Type {
+					reply.answers = append(reply.answers, record)
+				}
+			}
+			if cname != "" {
+				name = cname
+				continue
+			}
+			if !exists && len(reply.answers) == 0 {
+				reply.rcode = dnsmessage.RCodeNameError
+			}
+			break
+		}
+		if len(reply.answers) == 0 {
+			reply.authority = []dnsmessage.Resource{rrSOA(zone, 300, 60)}
+		}
+		return reply
+	}
+}
+
+func rrHeader(name string, rrType dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: rrType, Class: dnsmessage.ClassINET, TTL: ttl}
+}
+
+func rrA(name, ip string, ttl uint32) dnsmessage.Resource {
+	var a [4]byte
+	copy(a[:], net.ParseIP(ip).To4())
+	return dnsmessage.Resource{Header: rrHeader(name, dnsmessage.TypeA, ttl), Body: &dnsmessage.AResource{A: a}}
+}
+
+func rrAAAA(name, ip string, ttl uint32) dnsmessage.Resource {
+	var aaaa [16]byte
+	copy(aaaa[:], net.ParseIP(ip).To16())
+	return dnsmessage.Resource{Header: rrHeader(name, dnsmessage.TypeAAAA, ttl), Body: &dnsmessage.AAAAResource{AAAA: aaaa}}
+}
+
+func rrCNAME(name, target string, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: rrHeader(name, dnsmessage.TypeCNAME, ttl), Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(target)}}
+}
+
+func rrNS(name, server string, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: rrHeader(name, dnsmessage.TypeNS, ttl), Body: &dnsmessage.NSResource{NS: dnsmessage.MustNewName(server)}}
+}
+
+func rrPTR(name, target string, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: rrHeader(name, dnsmessage.TypePTR, ttl), Body: &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(target)}}
+}
+
+func rrTXT(name string, ttl uint32, txt ...string) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: rrHeader(name, dnsmessage.TypeTXT, ttl), Body: &dnsmessage.TXTResource{TXT: txt}}
+}
+
+func rrMX(name string, pref uint16, exchange string, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: rrHeader(name, dnsmessage.TypeMX, ttl), Body: &dnsmessage.MXResource{Pref: pref, MX: dnsmessage.MustNewName(exchange)}}
+}
+
+func rrSOA(zone string, ttl, minimum uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: rrHeader(zone, dnsmessage.TypeSOA, ttl), Body: &dnsmessage.SOAResource{
+		NS: dnsmessage.MustNewName("ns1." + zone), MBox: dnsmessage.MustNewName("hostmaster." + zone),
+		Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, MinTTL: minimum,
+	}}
+}
+
+// testZone is the zone most DNS tests ask for.
+var testZone = []dnsmessage.Resource{
+	rrA("host.example.test.", "192.0.2.1", 300),
+	rrAAAA("host.example.test.", "2001:db8::1", 300),
+	rrCNAME("www.example.test.", "web.example.test.", 60),
+	rrCNAME("web.example.test.", "host.example.test.", 120),
+	rrTXT("host.example.test.", 300, "v=spf1 -all", "second string"),
+	rrMX("example.test.", 10, "mail.example.test.", 300),
+	rrPTR("1.2.0.192.in-addr.arpa.", "host.example.test.", 300),
+}
+
+func TestQueryDNS(t *testing.T) {
+	server := startFakeDNS(t, "127.0.0.1:0", zoneHandler("example.test.", testZone...))
+	ctx := context.Background()
+
+	tests := []struct {
+		name, qtype string
+		status      string
+		data        []string
+		chain       []string
+	}{
+		{"host.example.test", "A", "NOERROR", []string{"192.0.2.1"}, nil},
+		{"v6:host.example.test", "AAAA", "NOERROR", []string{"2001:db8::1"}, nil},
+		{"www.example.test", "A", "NOERROR", []string{"192.0.2.1"},
+			[]string{"www.example.test.", "web.example.test.", "host.example.test."}},
+		{"host.example.test", "TXT", "NOERROR", []string{`"v=spf1 -all" "second string"`}, nil},
+		{"example.test", "MX", "NOERROR", []string{"10 mail.example.test."}, nil},
+		{"192.0.2.1", "PTR", "NOERROR", []string{"host.example.test."}, nil},
+		{"example.test", "A", "NODATA", nil, nil},
+		{"missing.example.test", "A", "NXDOMAIN", nil, nil},
+	}
+	for _, tt := range tests {
+		answer := QueryDNS(ctx, server, tt.name, tt.qtype, time.Second)
+		if answer.Err != nil {
+			t.Errorf("%s %s: %v", tt.name, tt.qtype, answer.Err)
+			continue
+		}
+		if status := answer.Status(); status != tt.status {
+			t.Errorf("%s %s: status %s, want %s", tt.name, tt.qtype, status, tt.status)
+		}
+		if data := answer.Data(); !slices.Equal(data, tt.data) {
+			t.Errorf("%s %s: data %q, want %q", tt.name, tt.qtype, data, tt.data)
+		}
+		if !slices.Equal(answer.CNAMEChain, tt.chain) {
+			t.Errorf("%s %s: CNAME chain %q, want %q", tt.name, tt.qtype, answer.CNAMEChain, tt.chain)
+		}
+		if !answer.Authoritative || answer.Server != server {
+			t.Errorf("%s %s: authoritative %v from %s, want an authoritative answer from %s",
+				tt.name, tt.qtype, answer.Authoritative, answer.Server, server)
+		}
+		if tt.status == "NXDOMAIN" || tt.status == "NODATA" {
+			if len(answer.Authority) != 1 || answer.Authority[0].Type != "SOA" {
+				t.Errorf("%s %s: authority %v, want the SOA of the zone", tt.name, tt.qtype, answer.Authority)
+			}
+		}
+	}
+
+	answer := QueryDNS(ctx, server, "host.example.test", "SPF", time.Second)
+	if answer.Err == nil || answer.Status() != "ERROR" {
+		t.Errorf("unsupported type: status %s, error %v, want ERROR", answer.Status(), answer.Err)
+	}
+}
+
+func TestQueryDNSTruncated(t *testing.T) {
+	var streamQueries atomic.Int32
+	zone := zoneHandler("example.test.", testZone...)
+	server := startFakeDNS(t, "127.0.0.1:0", func(q dnsmessage.Question, stream bool) fakeReply {
+		if !stream {
+			return fakeReply{truncated: true}
+		}
+		streamQueries.Add(1)
+		return zone(q, stream)
+	})
+
+	answer := QueryDNS(context.Background(), server, "host.example.test", "A", time.Second)
+	if answer.Err != nil || !answer.TCP || streamQueries.Load() != 1 {
+		t.Fatalf("truncated answer: error %v, TCP %v after %d TCP queries, want one TCP retry", answer.Err, answer.TCP, streamQueries.Load())
+	}
+	if data := answer.Data(); !slices.Equal(data, []string{"192.0.2.1"}) {
+		t.Errorf("truncated answer: data %q after the TCP retry", data)
+	}
+}
+
+func TestQueryDNSStrayDatagram(t *testing.T) {
+	zone := zoneHandler("example.test.", testZone...)
+	server := startFakeDNS(t, "127.0.0.1:0", func(q dnsmessage.Question, stream bool) fakeReply {
+		reply := zone(q, stream)
+		reply.stray = true
+		return reply
+	})
+
+	answer := QueryDNS(context.Background(), server, "host.example.test", "A", time.Second)
+	if answer.Err != nil || answer.Status() != "NOERROR" {
+		t.Errorf("answer after a stray datagram: status %s, error %v, want NOERROR", answer.Status(), answer.Err)
+	}
+}
+
+func TestQueryDNSTimeout(t *testing.T) {
+	server := startFakeDNS(t, "127.0.0.1:0", func(dnsmessage.Question, bool) fakeReply {
+		return fakeReply{drop: true}
+	})
+
+	start := time.Now()
+	answer := QueryDNS(context.Background(), server, "host.example.test", "A", 200*time.Millisecond)
+	if answer.Status() != "TIMEOUT" {
+		t.Errorf("silent server: status %s, error %v, want TIMEOUT", answer.Status(), answer.Err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("silent server: gave up after %v, want about the 200ms timeout", elapsed)
+	}
+}
+
+func TestParseAnswer(t *testing.T) {
+	question := dnsmessage.Question{Name: dnsmessage.MustNewName("host.example.test."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	pack := func(id uint16, response bool, q dnsmessage.Question) []byte {
+		message := dnsmessage.Message{Header: dnsmessage.Header{ID: id, Response: response}, Questions: []dnsmessage.Question{q}}
+		packed, err := message.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return packed
+	}
+	upper := question
+	upper.Name = dnsmessage.MustNewName("HOST.Example.TEST.")
+	other := question
+	other.Type = dnsmessage.TypeAAAA
+
+	tests := []struct {
+		name     string
+		response []byte
+		want     bool
+	}{
+		{"answer", pack(7, true, question), true},
+		{"letter case of DNS 0x20", pack(7, true, upper), true},
+		{"other ID", pack(8, true, question), false},
+		{"query, not response", pack(7, false, question), false},
+		{"other question", pack(7, true, other), false},
+		{"garbage", []byte{1, 2, 3}, false},
+	}
+	for _, tt := range tests {
+		if _, _, ok := parseAnswer(tt.response, 7, question); ok != tt.want {
+			t.Errorf("%s: parseAnswer = %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package pingotrace
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+)
+
+// systemDNSServer returns the address and port of the first name server in /etc/resolv.conf,
+// or the local host when there is none, as the Go resolver does.
+func systemDNSServer() string {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if ip := net.ParseIP(fields[1]); ip != nil {
+			return net.JoinHostPort(ip.String(), "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
@@ -0,0 +1,47 @@
+package pingotrace
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Flags of GetAdaptersAddresses that golang.org/x/sys/windows does not define
+const (
+	gaaFlagSkipAnycast   = 0x2
+	gaaFlagSkipMulticast = 0x4
+)
+
+// systemDNSServer returns the address and port of the first DNS server of the first adapter that is up,
+// or the local host when there is none.
+func systemDNSServer() string {
+	size := uint32(15000) // Recommended initial size of the GetAdaptersAddresses buffer
+	var buf []byte
+	for {
+		buf = make([]byte, size)
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, gaaFlagSkipAnycast|gaaFlagSkipMulticast,
+			0, (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])), &size)
+		if err == nil {
+			break
+		}
+		if err != windows.ERROR_BUFFER_OVERFLOW {
+			return "127.0.0.1:53"
+		}
+	}
+
+	for adapter := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); adapter != nil; adapter = adapter.Next {
+		if adapter.OperStatus != windows.IfOperStatusUp {
+			continue
+		}
+		for server := adapter.FirstDnsServerAddress; server != nil; server = server.Next {
+			ip := server.Address.IP()
+			// Site-local fec0:0:0:ffff::1-3 are placeholders Windows lists when IPv6 DNS is not configured
+			if ip == nil || ip.IsUnspecified() || ip.To4() == nil && ip[0] == 0xfe && ip[1] == 0xc0 {
+				continue
+			}
+			return net.JoinHostPort(ip.String(), "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
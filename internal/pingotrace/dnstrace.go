@@ -0,0 +1,302 @@
+package pingotrace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NameServer is a name server of a zone and the address it is asked at.
+type NameServer struct {
+	Name string // Host name, without trailing dot
+	Addr string // IP address, "" if none was found
+	Glue bool   // Addr came with the referral rather than from a lookup of Name
+	Err  error  // Why no address was found
+}
+
+// rootHints are the root servers, from the root hints file of IANA.
+var rootHints = []struct{ name, ipv4, ipv6 string }{
+	{"a.root-servers.net", "198.41.0.4", "2001:503:ba3e::2:30"},
+	{"b.root-servers.net", "170.247.170.2", "2801:1b8:10::b"},
+	{"c.root-servers.net", "192.33.4.12", "2001:500:2::c"},
+	{"d.root-servers.net", "199.7.91.13", "2001:500:2d::d"},
+	{"e.root-servers.net", "192.203.230.10", "2001:500:a8::e"},
+	{"f.root-servers.net", "192.5.5.241", "2001:500:2f::f"},
+	{"g.root-servers.net", "192.112.36.4", "2001:500:12::d0d"},
+	{"h.root-servers.net", "198.97.190.53", "2001:500:1::53"},
+	{"i.root-servers.net", "192.36.148.17", "2001:7fe::53"},
+	{"j.root-servers.net", "192.58.128.30", "2001:503:c27::2:30"},
+	{"k.root-servers.net", "193.0.14.129", "2001:7fd::1"},
+	{"l.root-servers.net", "199.7.83.42", "2001:500:9f::42"},
+	{"m.root-servers.net", "202.12.27.33", "2001:dc3::35"},
+}
+
+// RootHints returns the root servers at their IPv6 addresses for FamilyIPv6 and at their IPv4 addresses otherwise.
+func RootHints(family AddressFamily) []NameServer {
+	servers := make([]NameServer, len(rootHints))
+	for i, hint := range rootHints {
+		servers[i] = NameServer{Name: hint.name, Addr: hint.ipv4, Glue: true}
+		if family == FamilyIPv6 {
+			servers[i].Addr = hint.ipv6
+		}
+	}
+	return servers
+}
+
+// DNSTraceOptions controls how TraceDNS walks down the delegations.
+type DNSTraceOptions struct {
+	Roots    []NameServer  // Servers of the root zone to start from, RootHints of the family of the name if empty
+	Port     int           // Port every name server is asked on
+	Timeout  time.Duration // Wait for each answer
+	Resolver string        // Server that looks up name servers referred to without glue, "" for the system resolver
+	MaxDepth int           // Referrals followed before giving up
+}
+
+// DefaultDNSTraceOptions returns the options used by the DNS TRACE view: the root hints, port 53,
+// the timeout of DNS queries and up to 16 referrals.
+func DefaultDNSTraceOptions() DNSTraceOptions {
+	return DNSTraceOptions{
+		Port:     53,
+		Timeout:  DefaultDNSTimeout,
+		MaxDepth: 16,
+	}
+}
+
+// DNSTraceOutcome is how a name server answered during a delegation trace.
+type DNSTraceOutcome int
+
+const (
+	DNSTraceReferral   DNSTraceOutcome = iota // Delegated the name to the servers of a zone below its own
+	DNSTraceAnswer                            // Answered with authority: records, NXDOMAIN or NODATA
+	DNSTraceLame                              // Is a server of the zone but gave no authoritative answer nor referral down (lame delegation)
+	DNSTraceNoResponse                        // Did not answer in time
+	DNSTraceError                             // Could not be asked, such as a server without address
+)
+
+// String returns "REFERRAL", "ANSWER", "LAME", "NO RESPONSE" or "ERROR".
+func (o DNSTraceOutcome) String() string {
+	return [...]string{"REFERRAL", "ANSWER", "LAME", "NO RESPONSE", "ERROR"}[o]
+}
+
+// DNSTraceReply is the answer of one name server of a zone.
+type DNSTraceReply struct {
+	Server   NameServer
+	Outcome  DNSTraceOutcome
+	Referral string    // Zone delegated to by a referral, fully qualified
+	Answer   DNSAnswer // Answer as received, Err telling why there was none
+}
+
+// Detail returns what the outcome is about: the zone of a referral, the status of an answer,
+// why a server is lame, or the error that prevented an answer.
+func (r DNSTraceReply) Detail() string {
+	switch r.Outcome {
+	case DNSTraceReferral:
+		return r.Referral
+	case DNSTraceAnswer:
+		return r.Answer.Status()
+	case DNSTraceLame:
+		switch {
+		case r.Answer.RCode != DNSNoError:
+			return r.Answer.RCode.String()
+		case r.Answer.Authoritative:
+			return "authoritative for another zone"
+		default:
+			return "not authoritative"
+		}
+	case DNSTraceError:
+		if r.Server.Err != nil {
+			return r.Server.Err.Error()
+		}
+	}
+	if r.Answer.Err != nil {
+		return r.Answer.Err.Error()
+	}
+	return ""
+}
+
+// DNSTraceLevel is one step of a delegation trace: the servers of a zone asked for the name.
+type DNSTraceLevel struct {
+	Zone    string          // Zone the servers belong to, fully qualified, "." first
+	Replies []DNSTraceReply // Reply of every server of the zone, in the order of the delegation
+	Next    int             // Index in Replies of the reply followed to the next level, -1 for the last level
+}
+
+// DNSTrace is the walk from the root servers down to the servers that answer for a name.
+type DNSTrace struct {
+	Name   string // Name queried, fully qualified
+	Type   string // Record type queried
+	Levels []DNSTraceLevel
+	Answer *DNSTraceReply // Authoritative answer that ended the trace, nil if the trace broke off
+	Err    error          // Why the trace broke off
+}
+
+// TraceDNS follows the delegations of name from the root servers down, like dig +trace: it asks every
+// server of a zone for records of type qtype (one of DNSTypes) of name without recursion, and follows
+// the first referral to the servers of the zone below, until a server answers with authority.
+// A "v4:" or "v6:" prefix on name chooses the family of the server addresses, IPv4 by default.
+// Name servers referred to without glue are looked up with opts.Resolver.
+func TraceDNS(ctx context.Context, name, qtype string, opts DNSTraceOptions) DNSTrace {
+	trace := DNSTrace{Name: queryName(name, qtype), Type: strings.ToUpper(qtype)}
+	target, _ := SplitPort(name)
+	_, family := SplitFamily(target)
+	if family != FamilyIPv6 {
+		family = FamilyIPv4
+	}
+	servers := opts.Roots
+	if len(servers) == 0 {
+		servers = RootHints(family)
+	}
+
+	zone := "."
+	for range opts.MaxDepth {
+		level := DNSTraceLevel{Zone: zone, Replies: askNameServers(ctx, servers, zone, trace.Name, trace.Type, opts), Next: -1}
+		if ctx.Err() != nil {
+			trace.Levels = append(trace.Levels, level)
+			trace.Err = ctx.Err()
+			return trace
+		}
+		for i, reply := range level.Replies {
+			if reply.Outcome == DNSTraceAnswer {
+				level.Next = -1
+				trace.Levels = append(trace.Levels, level)
+				trace.Answer = &level.Replies[i]
+				return trace
+			}
+			if reply.Outcome == DNSTraceReferral && level.Next < 0 {
+				level.Next = i
+			}
+		}
+		trace.Levels = append(trace.Levels, level)
+		if level.Next < 0 {
+			trace.Err = fmt.Errorf("no server of %s answered or referred the query", zone)
+			return trace
+		}
+
+		referral := level.Replies[level.Next]
+		zone = referral.Referral
+		servers = referredServers(ctx, referral.Answer, zone, family, opts.Resolver)
+	}
+	trace.Err = fmt.Errorf("more than %d referrals", opts.MaxDepth)
+	return trace
+}
+
+// askNameServers asks every server of zone at once and classifies their replies.
+func askNameServers(ctx context.Context, servers []NameServer, zone, name, qtype string, opts DNSTraceOptions) []DNSTraceReply {
+	replies := make([]DNSTraceReply, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		replies[i].Server = server
+		if server.Addr == "" {
+			replies[i].Outcome = DNSTraceError
+			continue
+		}
+		wg.Add(1)
+		go func(reply *DNSTraceReply) {
+			defer wg.Done()
+			address := net.JoinHostPort(reply.Server.Addr, strconv.Itoa(opts.Port))
+			reply.Answer = queryDNS(ctx, address, name, qtype, opts.Timeout, false)
+			reply.Outcome, reply.Referral = classifyReply(reply.Answer, zone, name)
+		}(&replies[i])
+	}
+	wg.Wait()
+	return replies
+}
+
+// classifyReply tells what the answer of a server of zone to a query for name is, and the zone it refers to.
+func classifyReply(answer DNSAnswer, zone, name string) (DNSTraceOutcome, string) {
+	var netErr net.Error
+	switch {
+	case errors.Is(answer.Err, context.DeadlineExceeded) || errors.As(answer.Err, &netErr) && netErr.Timeout():
+		return DNSTraceNoResponse, ""
+	case answer.Err != nil:
+		return DNSTraceError, ""
+	case answer.RCode != DNSNoError && answer.RCode != DNSNXDomain:
+		return DNSTraceLame, ""
+	}
+	if child := referralZone(answer, zone, name); child != "" {
+		return DNSTraceReferral, child
+	}
+	if answer.Authoritative {
+		// A denial must come from the zone itself, not from a zone above it the server also serves
+		for _, record := range answer.Authority {
+			if record.Type == "SOA" && !inZone(record.Name, zone) {
+				return DNSTraceLame, ""
+			}
+		}
+		return DNSTraceAnswer, ""
+	}
+	return DNSTraceLame, ""
+}
+
+// referralZone returns the zone below zone, and above or at name, whose NS records the answer holds without
+// answering the question, or "" if the answer is no referral down. A referral to zone itself or above it is lame.
+func referralZone(answer DNSAnswer, zone, name string) string {
+	if len(answer.Records) > 0 || answer.RCode != DNSNoError {
+		return ""
+	}
+	for _, record := range answer.Authority {
+		if record.Type == "NS" && inZone(name, record.Name) && inZone(record.Name, zone) && !strings.EqualFold(record.Name, zone) {
+			return strings.ToLower(record.Name)
+		}
+	}
+	return ""
+}
+
+// referredServers returns the name servers of zone named by a referral, each at the first address of family
+// found in the glue, or else looked up with resolver.
+func referredServers(ctx context.Context, answer DNSAnswer, zone string, family AddressFamily, resolver string) []NameServer {
+	glueType := "A"
+	if family == FamilyIPv6 {
+		glueType = "AAAA"
+	}
+
+	var servers []NameServer
+	for _, record := range answer.Authority {
+		if record.Type != "NS" || !strings.EqualFold(record.Name, zone) {
+			continue
+		}
+		server := NameServer{Name: RemoveTrailingDot(strings.ToLower(record.Data))}
+		if slices.ContainsFunc(servers, func(s NameServer) bool { return s.Name == server.Name }) {
+			continue
+		}
+		for _, glue := range answer.Additional {
+			if glue.Type == glueType && strings.EqualFold(glue.Name, record.Data) {
+				server.Addr, server.Glue = glue.Data, true
+				break
+			}
+		}
+		servers = append(servers, server)
+	}
+
+	// Look up the servers without glue at once
+	var wg sync.WaitGroup
+	for i := range servers {
+		if servers[i].Glue {
+			continue
+		}
+		wg.Add(1)
+		go func(server *NameServer) {
+			defer wg.Done()
+			result := Lookup(ctx, WithFamily(server.Name, family), resolver)
+			if result.OK() {
+				server.Addr = result.Answer()
+			} else {
+				server.Err = fmt.Errorf("no address: %w", result.Err)
+			}
+		}(&servers[i])
+	}
+	wg.Wait()
+	return servers
+}
+
+// inZone reports whether the fully qualified name is zone or a name below it, ignoring letter case.
+func inZone(name, zone string) bool {
+	name, zone = strings.ToLower(name), strings.ToLower(zone)
+	return zone == "." || name == zone || strings.HasSuffix(name, "."+zone)
+}
@@ -0,0 +1,200 @@
+package pingotrace
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// referralHandler answers names below each child zone with a referral to its servers, and other names with
+// authority like zoneHandler. servers maps each child zone to the names of its servers and glue to their addresses.
+func referralHandler(zone string, servers map[string][]string, glue map[string]string) fakeHandler {
+	own := zoneHandler(zone)
+	return func(q dnsmessage.Question, stream bool) fakeReply {
+		for child, names := range servers {
+			if !inZone(q.Name.String(), child) {
+				continue
+			}
+			var reply fakeReply
+			for _, name := range names {
+				reply.authority = append(reply.authority, rrNS(child, name, 3600))
+				if address, ok := glue[name]; ok {
+					reply.additional = append(reply.additional, rrA(name, address, 3600))
+				}
+			}
+			return reply
+		}
+		return own(q, stream)
+	}
+}
+
+// startDelegations starts a fake delegation hierarchy on loopback addresses sharing one port:
+//
+//	127.0.0.2 root server, delegating test. to ns1.test. and ns2.test.
+//	127.0.0.3 ns1.test., delegating example.test. and lame.test.
+//	127.0.0.4 ns2.test., refusing every query
+//	127.0.0.5 ns.example.test., serving testZone
+//	127.0.0.6 silent.example.test., never answering
+//
+// example.test. also has a server without glue, ns.glueless.invalid., that resolver does not know.
+// It returns the trace options to walk the hierarchy with.
+func startDelegations(t *testing.T) DNSTraceOptions {
+	t.Helper()
+	root := startFakeDNS(t, "127.0.0.2:0", referralHandler(".",
+		map[string][]string{"test.": {"ns1.test.", "ns2.test."}},
+		map[string]string{"ns1.test.": "127.0.0.3", "ns2.test.": "127.0.0.4"}))
+	_, portText, _ := net.SplitHostPort(root)
+
+	startFakeDNS(t, "127.0.0.3:"+portText, referralHandler("test.",
+		map[string][]string{
+			"example.test.": {"ns.example.test.", "silent.example.test.", "ns.glueless.invalid."},
+			"lame.test.":    {"ns2.test."},
+		},
+		map[string]string{"ns.example.test.": "127.0.0.5", "silent.example.test.": "127.0.0.6", "ns2.test.": "127.0.0.4"}))
+	startFakeDNS(t, "127.0.0.4:"+portText, func(dnsmessage.Question, bool) fakeReply {
+		return fakeReply{rcode: dnsmessage.RCodeRefused}
+	})
+	startFakeDNS(t, "127.0.0.5:"+portText, zoneHandler("example.test.", testZone...))
+	startFakeDNS(t, "127.0.0.6:"+portText, func(dnsmessage.Question, bool) fakeReply {
+		return fakeReply{drop: true}
+	})
+	resolver := startFakeDNS(t, "127.0.0.1:0", zoneHandler("invalid."))
+
+	opts := DefaultDNSTraceOptions()
+	opts.Roots = []NameServer{{Name: "root.test", Addr: "127.0.0.2", Glue: true}}
+	opts.Port, _ = strconv.Atoi(portText)
+	opts.Timeout = 300 * time.Millisecond
+	opts.Resolver = resolver
+	return opts
+}
+
+func TestTraceDNS(t *testing.T) {
+	opts := startDelegations(t)
+
+	type level struct {
+		zone     string
+		outcomes []DNSTraceOutcome
+		next     int
+	}
+	tests := []struct {
+		name, qtype string
+		levels      []level
+		status      string // Status of the answer, "" if the trace breaks off
+		data        []string
+	}{
+		{"host.example.test", "A", []level{
+			{".", []DNSTraceOutcome{DNSTraceReferral}, 0},
+			{"test.", []DNSTraceOutcome{DNSTraceReferral, DNSTraceLame}, 0},
+			{"example.test.", []DNSTraceOutcome{DNSTraceAnswer, DNSTraceNoResponse, DNSTraceError}, -1},
+		}, "NOERROR", []string{"192.0.2.1"}},
+		{"missing.example.test", "MX", []level{
+			{".", []DNSTraceOutcome{DNSTraceReferral}, 0},
+			{"test.", []DNSTraceOutcome{DNSTraceReferral, DNSTraceLame}, 0},
+			{"example.test.", []DNSTraceOutcome{DNSTraceAnswer, DNSTraceNoResponse, DNSTraceError}, -1},
+		}, "NXDOMAIN", nil},
+		{"nothing.test", "A", []level{
+			{".", []DNSTraceOutcome{DNSTraceReferral}, 0},
+			{"test.", []DNSTraceOutcome{DNSTraceAnswer, DNSTraceLame}, -1},
+		}, "NXDOMAIN", nil},
+		{"www.lame.test", "A", []level{
+			{".", []DNSTraceOutcome{DNSTraceReferral}, 0},
+			{"test.", []DNSTraceOutcome{DNSTraceReferral, DNSTraceLame}, 0},
+			{"lame.test.", []DNSTraceOutcome{DNSTraceLame}, -1},
+		}, "", nil},
+	}
+	for _, tt := range tests {
+		trace := TraceDNS(context.Background(), tt.name, tt.qtype, opts)
+		if trace.Name != tt.name+"." || trace.Type != tt.qtype {
+			t.Errorf("%s: traced %s %s", tt.name, trace.Name, trace.Type)
+		}
+		if len(trace.Levels) != len(tt.levels) {
+			t.Errorf("%s: %d levels, want %d", tt.name, len(trace.Levels), len(tt.levels))
+			continue
+		}
+		for i, want := range tt.levels {
+			got := trace.Levels[i]
+			var outcomes []DNSTraceOutcome
+			for _, reply := range got.Replies {
+				outcomes = append(outcomes, reply.Outcome)
+			}
+			if got.Zone != want.zone || got.Next != want.next || !slices.Equal(outcomes, want.outcomes) {
+				t.Errorf("%s level %d: zone %s, outcomes %v, next %d, want zone %s, outcomes %v, next %d",
+					tt.name, i, got.Zone, outcomes, got.Next, want.zone, want.outcomes, want.next)
+			}
+		}
+
+		if tt.status == "" {
+			if trace.Answer != nil || trace.Err == nil {
+				t.Errorf("%s: answer %v, error %v, want the trace to break off", tt.name, trace.Answer, trace.Err)
+			}
+			continue
+		}
+		if trace.Answer == nil || trace.Err != nil {
+			t.Errorf("%s: answer %v, error %v, want an answer", tt.name, trace.Answer, trace.Err)
+			continue
+		}
+		if status := trace.Answer.Answer.Status(); status != tt.status || !slices.Equal(trace.Answer.Answer.Data(), tt.data) {
+			t.Errorf("%s: answer %s %q, want %s %q", tt.name, status, trace.Answer.Answer.Data(), tt.status, tt.data)
+		}
+	}
+}
+
+func TestTraceDNSServers(t *testing.T) {
+	opts := startDelegations(t)
+	trace := TraceDNS(context.Background(), "host.example.test", "A", opts)
+	if len(trace.Levels) != 3 {
+		t.Fatalf("%d levels, want 3", len(trace.Levels))
+	}
+
+	referral := trace.Levels[0].Replies[0]
+	if referral.Referral != "test." || referral.Detail() != "test." {
+		t.Errorf("root referral: zone %q, detail %q, want test.", referral.Referral, referral.Detail())
+	}
+	if lame := trace.Levels[1].Replies[1]; lame.Server.Name != "ns2.test" || lame.Detail() != "REFUSED" {
+		t.Errorf("lame server %s: detail %q, want ns2.test REFUSED", lame.Server.Name, lame.Detail())
+	}
+
+	want := []NameServer{
+		{Name: "ns.example.test", Addr: "127.0.0.5", Glue: true},
+		{Name: "silent.example.test", Addr: "127.0.0.6", Glue: true},
+		{Name: "ns.glueless.invalid"},
+	}
+	for i, reply := range trace.Levels[2].Replies {
+		got := reply.Server
+		if got.Name != want[i].Name || got.Addr != want[i].Addr || got.Glue != want[i].Glue {
+			t.Errorf("server %d of example.test.: %+v, want %+v", i, got, want[i])
+		}
+	}
+	glueless := trace.Levels[2].Replies[2]
+	if glueless.Server.Err == nil || !strings.Contains(glueless.Detail(), "NXDOMAIN") {
+		t.Errorf("server without glue: detail %q, want the NXDOMAIN of its lookup", glueless.Detail())
+	}
+	if silent := trace.Levels[2].Replies[1]; silent.Answer.Status() != "TIMEOUT" {
+		t.Errorf("silent server: status %s, want TIMEOUT", silent.Answer.Status())
+	}
+}
+
+func TestTraceDNSLimits(t *testing.T) {
+	opts := startDelegations(t)
+
+	shallow := opts
+	shallow.MaxDepth = 2
+	trace := TraceDNS(context.Background(), "host.example.test", "A", shallow)
+	if trace.Answer != nil || trace.Err == nil || len(trace.Levels) != 2 {
+		t.Errorf("2 referrals at most: %d levels, error %v, want the trace to stop after 2 levels", len(trace.Levels), trace.Err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	trace = TraceDNS(ctx, "host.example.test", "A", opts)
+	if !errors.Is(trace.Err, context.Canceled) || trace.Answer != nil {
+		t.Errorf("cancelled trace: error %v, want %v", trace.Err, context.Canceled)
+	}
+}
@@ -0,0 +1,132 @@
+package pingotrace
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/icmp"
+)
+
+// MPLSLabel is one entry of the MPLS label stack a router quoted in an ICMP error (RFC 4950).
+type MPLSLabel struct {
+	Label  int
+	TC     int  // Traffic class, formerly EXP
+	Bottom bool // Bottom of the stack
+	TTL    int
+}
+
+// String formats the label as "Label=24001 TC=0 S=1 TTL=1".
+func (l MPLSLabel) String() string {
+	s := 0
+	if l.Bottom {
+		s = 1
+	}
+	return fmt.Sprintf("Label=%d TC=%d S=%d TTL=%d", l.Label, l.TC, s, l.TTL)
+}
+
+// InterfaceRole tells which interface of the router an InterfaceInfo describes.
+type InterfaceRole int
+
+const (
+	InterfaceIncoming InterfaceRole = iota // Interface the probe arrived on
+	InterfaceSubIP                         // Sub-IP component of the incoming interface
+	InterfaceOutgoing                      // Interface the probe would have left on
+	InterfaceNextHop                       // Next hop the probe would have been sent to
+)
+
+// String returns the role as shown in the trace output.
+func (r InterfaceRole) String() string {
+	switch r {
+	case InterfaceSubIP:
+		return "Sub-IP interface"
+	case InterfaceOutgoing:
+		return "Outgoing interface"
+	case InterfaceNextHop:
+		return "Next hop"
+	default:
+		return "Incoming interface"
+	}
+}
+
+// InterfaceInfo is an interface information object a router added to an ICMP error (RFC 5837).
+// Fields the router did not include are zero.
+type InterfaceInfo struct {
+	Role  InterfaceRole
+	Index int
+	Name  string
+	MTU   int
+	Addr  net.IP
+}
+
+// String formats the object as e.g. "Incoming interface: index 3, ge-0/0/1, 192.0.2.1, MTU 1500".
+func (i InterfaceInfo) String() string {
+	var fields []string
+	if i.Index != 0 {
+		fields = append(fields, fmt.Sprintf("index %d", i.Index))
+	}
+	if i.Name != "" {
+		fields = append(fields, i.Name)
+	}
+	if i.Addr != nil {
+		fields = append(fields, i.Addr.String())
+	}
+	if i.MTU != 0 {
+		fields = append(fields, fmt.Sprintf("MTU %d", i.MTU))
+	}
+	return fmt.Sprintf("%s: %s", i.Role, strings.Join(fields, ", "))
+}
+
+// Extension object class of interface information; MPLS label stacks are recognised by their Go type
+const classInterfaceInfo = 2
+
+// replyExtensions returns the MPLS label stack and interface information objects of an ICMP error.
+func replyExtensions(reply *icmp.Message) ([]MPLSLabel, []InterfaceInfo) {
+	var extensions []icmp.Extension
+	switch body := reply.Body.(type) {
+	case *icmp.TimeExceeded:
+		extensions = body.Extensions
+	case *icmp.DstUnreach:
+		extensions = body.Extensions
+	case *icmp.ParamProb:
+		extensions = body.Extensions
+	}
+
+	var labels []MPLSLabel
+	var interfaces []InterfaceInfo
+	for _, extension := range extensions {
+		switch ext := extension.(type) {
+		case *icmp.MPLSLabelStack:
+			for _, label := range ext.Labels {
+				labels = append(labels, MPLSLabel{Label: label.Label, TC: label.TC, Bottom: label.S, TTL: label.TTL})
+			}
+		case *icmp.InterfaceInfo:
+			if ext.Class != classInterfaceInfo {
+				continue
+			}
+			// The role is in the two high bits of the sub-type
+			info := InterfaceInfo{Role: InterfaceRole(ext.Type >> 6)}
+			if ext.Interface != nil {
+				info.Index, info.Name, info.MTU = ext.Interface.Index, ext.Interface.Name, ext.Interface.MTU
+			}
+			if ext.Addr != nil {
+				info.Addr = ext.Addr.IP
+			}
+			interfaces = append(interfaces, info)
+		}
+	}
+	return labels, interfaces
+}
+
+// Extensions returns one line per MPLS label and interface information object of the probe's answer,
+// as shown under the hop in the trace output.
+func (p Probe) Extensions() []string {
+	var lines []string
+	for _, label := range p.MPLS {
+		lines = append(lines, "MPLS "+label.String())
+	}
+	for _, info := range p.Interfaces {
+		lines = append(lines, info.String())
+	}
+	return lines
+}
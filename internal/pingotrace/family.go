@@ -0,0 +1,140 @@
+package pingotrace
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// AddressFamily is the IP version preferred for a target.
+// A target can carry its preference as a "v4:" or "v6:" prefix, e.g. "v6:example.com".
+type AddressFamily int
+
+const (
+	FamilyAny  AddressFamily = iota // IPv4 if the name has an A record, IPv6 otherwise
+	FamilyIPv4                      // Only A records / IPv4 literals
+	FamilyIPv6                      // Only AAAA records / IPv6 literals
+)
+
+// Target prefixes selecting the address family
+const (
+	prefixIPv4 = "v4:"
+	prefixIPv6 = "v6:"
+)
+
+// Network returns the network name used with net.ResolveIPAddr for the family.
+func (f AddressFamily) Network() string {
+	switch f {
+	case FamilyIPv4:
+		return "ip4"
+	case FamilyIPv6:
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+// SplitFamily removes a "v4:" or "v6:" prefix from target and returns the bare host and its family.
+func SplitFamily(target string) (string, AddressFamily) {
+	lower := strings.ToLower(target)
+	switch {
+	case strings.HasPrefix(lower, prefixIPv4):
+		return target[len(prefixIPv4):], FamilyIPv4
+	case strings.HasPrefix(lower, prefixIPv6):
+		return target[len(prefixIPv6):], FamilyIPv6
+	}
+	return target, FamilyAny
+}
+
+// WithFamily adds the prefix for family to host. FamilyAny leaves host unchanged.
+func WithFamily(host string, family AddressFamily) string {
+	switch family {
+	case FamilyIPv4:
+		return prefixIPv4 + host
+	case FamilyIPv6:
+		return prefixIPv6 + host
+	}
+	return host
+}
+
+// ParseURL returns the URL of an http or https target such as "https://example.com/health",
+// ignoring its family prefix, or nil for any other target.
+func ParseURL(target string) *url.URL {
+	rawURL, _ := SplitFamily(target)
+	lower := strings.ToLower(rawURL)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	return u
+}
+
+// SplitPort removes a ":port" suffix from target and returns the rest, family prefix included, and the port.
+// The port is 0 if target has none. IPv6 literals take a port only in brackets, e.g. "[2001:db8::1]:443".
+// For a URL target, the host and the port written in the URL are returned.
+func SplitPort(target string) (string, int) {
+	host, family := SplitFamily(target)
+	if u := ParseURL(host); u != nil {
+		port, _ := strconv.Atoi(u.Port())
+		return WithFamily(u.Hostname(), family), port
+	}
+	i := strings.LastIndex(host, ":")
+	if i < 0 {
+		return target, 0
+	}
+	if strings.HasPrefix(host, "[") {
+		if host[i-1] != ']' {
+			return target, 0
+		}
+	} else if strings.Count(host, ":") > 1 {
+		// An IPv6 literal without brackets
+		return target, 0
+	}
+	port, err := strconv.Atoi(host[i+1:])
+	if err != nil || port < 1 || port > 0xffff {
+		return target, 0
+	}
+	return WithFamily(strings.Trim(host[:i], "[]"), family), port
+}
+
+// JoinPort adds ":port" to host, which may carry a family prefix, bracketing IPv6 literals.
+// A zero port leaves host unchanged.
+func JoinPort(host string, port int) string {
+	if port == 0 {
+		return host
+	}
+	host, family := SplitFamily(host)
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return WithFamily(host+":"+strconv.Itoa(port), family)
+}
+
+// ResolveTarget resolves a target, honouring its family prefix and ignoring its port, to a single IP address.
+func ResolveTarget(target string) (*net.IPAddr, error) {
+	target, _ = SplitPort(target)
+	host, family := SplitFamily(target)
+	return net.ResolveIPAddr(family.Network(), strings.Trim(host, "[]"))
+}
+
+// CheckIP checks if a target, ignoring its family prefix and port, is an IPv4 or IPv6 literal.
+func CheckIP(target string) bool {
+	target, _ = SplitPort(target)
+	host, _ := SplitFamily(target)
+	return net.ParseIP(strings.Trim(host, "[]")) != nil
+}
+
+// ParseIP returns the first IPv6 or IPv4 address found in text, or an empty string.
+func ParseIP(text string) string {
+	for _, field := range strings.Fields(text) {
+		field = strings.Trim(field, "[](),")
+		if net.ParseIP(field) != nil {
+			return field
+		}
+	}
+	return ParseIPv4(text)
+}
@@ -0,0 +1,159 @@
+package pingotrace
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+)
+
+// FCrDNSIssue is a set of problems found by a forward-confirmed reverse DNS check.
+type FCrDNSIssue int
+
+const (
+	FCrDNSNoAddress   FCrDNSIssue = 1 << iota // The name resolves to no address
+	FCrDNSMissingPTR                          // The address has no PTR record
+	FCrDNSMultiplePTR                         // The address has more than one PTR record
+	FCrDNSMismatch                            // No name of the PTR records resolves back to the address
+	FCrDNSStale                               // A name of the PTR records does not exist any more
+	FCrDNSOtherName                           // The PTR records of an address of a name name other hosts only
+)
+
+// Names of the issues, in the order of their bits
+var fcrdnsIssueNames = []string{"NO ADDRESS", "MISSING PTR", "MULTIPLE PTR", "MISMATCH", "STALE PTR", "OTHER NAME"}
+
+// String returns the names of the issues in the set separated by commas, or "" for none.
+func (i FCrDNSIssue) String() string {
+	var names []string
+	for bit, name := range fcrdnsIssueNames {
+		if i&(1<<bit) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// FCrDNSResult is the forward-confirmed reverse DNS check of one address.
+type FCrDNSResult struct {
+	Input     string       // Input as given
+	Address   string       // Address checked: the input itself, or one of the addresses the input name resolves to
+	PTRs      []string     // Names of the PTR records of Address
+	Forward   []string     // Addresses the names of PTRs resolve to, in the family of Address
+	Confirmed bool         // A name of PTRs resolves back to Address
+	Issues    FCrDNSIssue  // Problems found on the way
+	Err       *LookupError // Why a lookup failed with an error other than NXDOMAIN or NODATA, leaving the check undecided
+}
+
+// Status returns "PASS" if the address is forward-confirmed, "ERROR" if a lookup failed, and "FAIL" otherwise.
+func (r FCrDNSResult) Status() string {
+	switch {
+	case r.Confirmed:
+		return "PASS"
+	case r.Err != nil:
+		return "ERROR"
+	default:
+		return "FAIL"
+	}
+}
+
+// FCrDNS checks that every input is forward-confirmed: an IP address must have a PTR record whose name
+// resolves back to it, and so must every address a name resolves to. Lookups go to server, "host:port",
+// or to the system resolver if server is empty. It returns one result per IP input and one per address
+// of each name input, in the order of inputs.
+func FCrDNS(ctx context.Context, inputs []string, server string) []FCrDNSResult {
+	results := make([][]FCrDNSResult, len(inputs))
+	ForEachParallel(len(inputs), DefaultParallel, func(i int) {
+		results[i] = fcrdnsInput(ctx, inputs[i], server)
+	})
+	return slices.Concat(results...)
+}
+
+// fcrdnsInput checks one input: the input itself if it is an IP address, or every address of the name.
+func fcrdnsInput(ctx context.Context, input, server string) []FCrDNSResult {
+	target, _ := SplitPort(input)
+	host, _ := SplitFamily(target)
+	host = strings.Trim(host, "[]")
+	if CheckIP(input) {
+		return []FCrDNSResult{fcrdnsAddress(ctx, input, "", host, server)}
+	}
+
+	forward := Lookup(ctx, input, server)
+	if !forward.OK() {
+		result := FCrDNSResult{Input: input}
+		if isNegative(forward.Err) {
+			result.Issues = FCrDNSNoAddress
+		} else {
+			result.Err = forward.Err
+		}
+		return []FCrDNSResult{result}
+	}
+	results := make([]FCrDNSResult, len(forward.Answers))
+	for i, address := range forward.Answers {
+		results[i] = fcrdnsAddress(ctx, input, RemoveTrailingDot(host), address, server)
+	}
+	return results
+}
+
+// fcrdnsAddress looks up the PTR records of address and the addresses of their names.
+// Name is the name input address belongs to, "" if the input is address itself.
+func fcrdnsAddress(ctx context.Context, input, name, address, server string) FCrDNSResult {
+	result := FCrDNSResult{Input: input, Address: address}
+	reverse := Lookup(ctx, address, server)
+	if !reverse.OK() {
+		if isNegative(reverse.Err) {
+			result.Issues |= FCrDNSMissingPTR
+		} else {
+			result.Err = reverse.Err
+		}
+		return result
+	}
+	result.PTRs = reverse.Answers
+	if len(result.PTRs) > 1 {
+		result.Issues |= FCrDNSMultiplePTR
+	}
+
+	// Only addresses of the family of address can confirm it
+	prefix := "v4:"
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		prefix = "v6:"
+	}
+	for _, ptr := range result.PTRs {
+		forward := Lookup(ctx, prefix+ptr, server)
+		switch {
+		case forward.OK():
+			for _, answer := range forward.Answers {
+				if !slices.Contains(result.Forward, answer) {
+					result.Forward = append(result.Forward, answer)
+				}
+			}
+		case forward.Err.Status == "NXDOMAIN":
+			result.Issues |= FCrDNSStale
+		case !isNegative(forward.Err) && result.Err == nil:
+			result.Err = forward.Err
+		}
+	}
+	result.Confirmed = containsAddress(result.Forward, address)
+	if !result.Confirmed && result.Err == nil {
+		result.Issues |= FCrDNSMismatch
+	}
+	if name != "" && !slices.ContainsFunc(result.PTRs, func(ptr string) bool { return strings.EqualFold(ptr, name) }) {
+		result.Issues |= FCrDNSOtherName
+	}
+	return result
+}
+
+// isNegative reports whether a lookup failed because the records do not exist, rather than for lack of an answer.
+func isNegative(err *LookupError) bool {
+	return err.Status == "NXDOMAIN" || err.Status == "NODATA"
+}
+
+// containsAddress reports whether addresses holds address, comparing IP addresses by value.
+func containsAddress(addresses []string, address string) bool {
+	ip := net.ParseIP(address)
+	for _, a := range addresses {
+		if other := net.ParseIP(a); other != nil && other.Equal(ip) || a == address {
+			return true
+		}
+	}
+	return false
+}
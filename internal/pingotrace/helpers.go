@@ -3,7 +3,6 @@ package pingotrace
 import (
 	"bufio"
 	"log"
-	"net"
 	"os"
 	"strings"
 )
@@ -15,34 +14,26 @@ func RemoveTrailingDot(s string) string {
 	return s
 }
 
-func RemoveDuplicatesMap(keysMap map[string][]interface{}) map[string][]interface{} {
-	cleanMap := make(map[string][]interface{})
+// RemoveDuplicateLookups drops the results that would probe the same target as an earlier one,
+// keeping the order: IP inputs and failed lookups are compared by input, URLs are always kept apart,
+// and names by their address and port, so that a name and its address are probed once.
+func RemoveDuplicateLookups(results []LookupResult) []LookupResult {
+	var clean []LookupResult
 	seen := make(map[string]bool)
 
-	for key, val := range keysMap {
-		if val[len(val)-1] == true { // Ensure that the last element is true
-			// Handle case where key is IP
-			ipInKey := net.ParseIP(key) != nil
-			if ipInKey && !seen[key] {
-				cleanMap[key] = val
-				seen[key] = true
-			}
-
-			// Handle case where value is IP
-			if ipInValue, ok := val[0].(string); ok {
-				if net.ParseIP(ipInValue) != nil && !seen[ipInValue] {
-					cleanMap[key] = val
-					seen[ipInValue] = true
-				}
-			}
-		} else { // If the last element is not true, just compare the keys
-			if !seen[key] {
-				cleanMap[key] = val
-				seen[key] = true
-			}
+	for _, result := range results {
+		key := result.Input
+		if result.OK() && !CheckIP(result.Input) && ParseURL(result.Input) == nil {
+			// The same address on another TCP port is not a duplicate
+			_, port := SplitPort(result.Input)
+			key = JoinPort(result.Answer(), port)
+		}
+		if !seen[key] {
+			clean = append(clean, result)
+			seen[key] = true
 		}
 	}
-	return cleanMap
+	return clean
 }
 
 func RemoveDuplicatesList(elements []string) []string {
@@ -0,0 +1,181 @@
+package pingotrace
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// Most bytes of a response body read by an HTTP probe
+const httpMaxBody = 16 << 20
+
+// HTTPResult is the outcome of one HTTP probe. The embedded PingResult holds the total time as RTT,
+// so that HTTP probes feed PingStats like echo requests do.
+type HTTPResult struct {
+	PingResult
+	URL        string
+	StatusCode int    // 0 if no response was received
+	Status     string // Status line, e.g. "200 OK"
+	Proto      string // e.g. "HTTP/1.1" or "HTTP/2.0"
+	Bytes      int64  // Size of the response body, of which at most 16 MiB are read
+
+	DNS     time.Duration // Name resolution
+	Connect time.Duration // TCP handshake
+	TLS     time.Duration // TLS handshake, zero for http URLs
+	TTFB    time.Duration // From the start of the probe to the first byte of the response
+	Total   time.Duration // From the start of the probe to the end of the body or the failure
+}
+
+// Label returns the grid label: the total time, or the error label, with the status code for HTTP errors.
+func (r HTTPResult) Label() string {
+	var pingErr *PingError
+	if errors.As(r.Err, &pingErr) && pingErr.Kind == PingHTTPError {
+		return fmt.Sprintf("HTTP %d", pingErr.Code)
+	}
+	return r.PingResult.Label()
+}
+
+// String formats the response and the timing breakdown, e.g.
+// "200 OK, HTTP/1.1, 5321 bytes; DNS 1.02 ms, connect 2.10 ms, TLS 15.33 ms, TTFB 40.12 ms, total 45.20 ms".
+func (r HTTPResult) String() string {
+	timings := fmt.Sprintf("DNS %s, connect %s, TLS %s, TTFB %s, total %s",
+		formatMs(r.DNS), formatMs(r.Connect), formatMs(r.TLS), formatMs(r.TTFB), formatMs(r.Total))
+	if r.StatusCode == 0 {
+		return fmt.Sprintf("%s; %s", r.Err, timings)
+	}
+	return fmt.Sprintf("%s, %s, %d bytes; %s", r.Status, r.Proto, r.Bytes, timings)
+}
+
+// HTTPProbe sends a GET request for rawURL, an http or https URL with an optional "v4:" or "v6:" prefix,
+// reads the response and reports the time spent in each phase. Every probe opens a new connection so that
+// DNS, connect and TLS are measured each time. Redirects are not followed, a 3xx status is the answer.
+// Transport failures, and 4xx and 5xx status codes as PingHTTPError, are reported through Err as a *PingError.
+func HTTPProbe(ctx context.Context, rawURL string, timeout time.Duration) HTTPResult {
+	result := HTTPResult{URL: rawURL}
+	u := ParseURL(rawURL)
+	if u == nil {
+		result.Err = &PingError{Kind: PingSocketError, Err: fmt.Errorf("%q is not an http or https URL", rawURL)}
+		return result
+	}
+	if timeout <= 0 {
+		result.Err = &PingError{Kind: PingSocketError, Err: errors.New("timeout must be positive")}
+		return result
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The address is resolved here rather than by the transport so that the family prefix is honoured
+	// and the lookup cache shared by every tool is used
+	startTime := time.Now()
+	address := u.Hostname()
+	if !CheckIP(rawURL) {
+		lookup := Lookup(probeCtx, rawURL, "")
+		if !lookup.OK() {
+			result.DNS = time.Since(startTime)
+			result.Total = result.DNS
+			if probeCtx.Err() != nil {
+				result.Err = &PingError{Kind: PingTimeout, Err: fmt.Errorf("unable to resolve %s: %w", u.Hostname(), probeCtx.Err())}
+			} else {
+				result.Err = &PingError{Kind: PingSocketError, Err: fmt.Errorf("unable to resolve %s: %w", u.Hostname(), lookup.Err)}
+			}
+			return result
+		}
+		address = lookup.Answer()
+	}
+	ipAddr, err := net.ResolveIPAddr("ip", address)
+	result.DNS = time.Since(startTime)
+	if err != nil {
+		result.Total = result.DNS
+		result.Err = &PingError{Kind: PingSocketError, Err: err}
+		return result
+	}
+	result.Addr = ipAddr
+
+	var connected bool
+	var tlsStart time.Time
+	var tlsErr error
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			var dialer net.Dialer
+			connectStart := time.Now()
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ipAddr.String(), port))
+			if err == nil {
+				result.Connect = time.Since(connectStart)
+				result.From = conn.RemoteAddr()
+				connected = true
+			}
+			return conn, err
+		},
+		DisableKeepAlives: true,
+		ForceAttemptHTTP2: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			result.TLS, tlsErr = time.Since(tlsStart), err
+		},
+		GotFirstResponseByte: func() {
+			result.TTFB = time.Since(startTime)
+		},
+	}
+
+	request, err := http.NewRequestWithContext(httptrace.WithClientTrace(probeCtx, trace), http.MethodGet, u.String(), nil)
+	if err != nil {
+		result.Err = &PingError{Kind: PingSocketError, Err: err}
+		return result
+	}
+	request.Header.Set("User-Agent", "PinGoTrace")
+
+	response, err := client.Do(request)
+	if err == nil {
+		defer response.Body.Close()
+		result.StatusCode, result.Status, result.Proto = response.StatusCode, response.Status, response.Proto
+		result.Bytes, err = io.Copy(io.Discard, io.LimitReader(response.Body, httpMaxBody))
+	}
+	result.Total = time.Since(startTime)
+
+	var netErr net.Error
+	switch {
+	case ctx.Err() != nil:
+		result.Err = &PingError{Kind: PingTimeout, Err: ctx.Err()}
+	case err != nil && !connected:
+		// A connection attempt cut short by the probe timeout is FILTERED, like a TCP connect ping
+		result.Err = connectError(err)
+	case err != nil && probeCtx.Err() != nil:
+		result.Err = &PingError{Kind: PingTimeout, Err: probeCtx.Err()}
+	case tlsErr != nil:
+		result.Err = &PingError{Kind: PingTLSError, Err: tlsErr}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		result.Err = &PingError{Kind: PingTimeout}
+	case err != nil:
+		result.Err = &PingError{Kind: PingSocketError, Err: err}
+	case result.StatusCode >= 400:
+		result.Err = &PingError{Kind: PingHTTPError, Code: result.StatusCode}
+	default:
+		result.RTT = result.Total
+	}
+	if result.Err != nil && result.StatusCode == 0 {
+		// Only an answer identifies who answered
+		result.From = nil
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package pingotrace
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// httpTestHandler serves the paths the HTTP probe tests ask for.
+func httpTestHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/ok":
+		w.Write([]byte(strings.Repeat("x", 1000)))
+	case "/down":
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case "/moved":
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	case "/slow":
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(httpTestHandler))
+	defer server.Close()
+
+	tests := []struct {
+		path       string
+		statusCode int
+		kind       PingErrorKind // 0 for success
+		label      string
+	}{
+		{"/ok", 200, 0, ""},
+		{"/down", 503, PingHTTPError, "HTTP 503"},
+		{"/moved", 302, 0, ""}, // Redirects are reported, not followed
+		{"/slow", 0, PingTimeout, "TIMEOUT"},
+	}
+	for _, tt := range tests {
+		result := HTTPProbe(context.Background(), server.URL+tt.path, 500*time.Millisecond)
+		if result.StatusCode != tt.statusCode {
+			t.Errorf("%s: status code %d, want %d", tt.path, result.StatusCode, tt.statusCode)
+		}
+		if tt.kind == 0 {
+			if result.Err != nil {
+				t.Errorf("%s: unexpected error %v", tt.path, result.Err)
+			} else if result.RTT <= 0 || result.RTT != result.Total {
+				t.Errorf("%s: RTT %v, want the total time %v", tt.path, result.RTT, result.Total)
+			}
+			continue
+		}
+		pingErr, ok := result.Err.(*PingError)
+		if !ok || pingErr.Kind != tt.kind {
+			t.Errorf("%s: error %v, want kind %v", tt.path, result.Err, tt.kind)
+			continue
+		}
+		if label := result.Label(); label != tt.label {
+			t.Errorf("%s: label %q, want %q", tt.path, label, tt.label)
+		}
+	}
+
+	result := HTTPProbe(context.Background(), server.URL+"/ok", time.Second)
+	if result.Bytes != 1000 || result.Proto != "HTTP/1.1" || result.TLS != 0 {
+		t.Errorf("/ok: %d bytes over %s with TLS time %v, want 1000 bytes over HTTP/1.1 without TLS", result.Bytes, result.Proto, result.TLS)
+	}
+}
+
+func TestHTTPProbeTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(httpTestHandler))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // The failed handshake is expected
+	server.StartTLS()
+	defer server.Close()
+
+	// The test certificate is not trusted by the system
+	result := HTTPProbe(context.Background(), server.URL+"/ok", time.Second)
+	if pingErr, ok := result.Err.(*PingError); !ok || pingErr.Kind != PingTLSError {
+		t.Errorf("untrusted certificate: error %v, want a TLS error", result.Err)
+	}
+	if result.Connect <= 0 {
+		t.Errorf("untrusted certificate: connect time %v, want it measured", result.Connect)
+	}
+}
+
+func TestHTTPProbeRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	result := HTTPProbe(context.Background(), "http://"+address+"/", time.Second)
+	if pingErr, ok := result.Err.(*PingError); !ok || pingErr.Kind != PingRefused {
+		t.Errorf("closed port: error %v, want REFUSED", result.Err)
+	}
+	if result.From != nil {
+		t.Errorf("closed port: From %v, want nil without an answer", result.From)
+	}
+}
+
+func TestHTTPProbeInvalid(t *testing.T) {
+	for _, target := range []string{"example.com", "ftp://example.com/"} {
+		result := HTTPProbe(context.Background(), target, time.Second)
+		if pingErr, ok := result.Err.(*PingError); !ok || pingErr.Kind != PingSocketError {
+			t.Errorf("%s: error %v, want a socket error", target, result.Err)
+		}
+	}
+}
+
+func TestHTTPProbeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := HTTPProbe(ctx, "http://pingotrace.invalid/", time.Second)
+	if pingErr, ok := result.Err.(*PingError); !ok || pingErr.Kind != PingTimeout {
+		t.Errorf("cancelled probe: error %v, want TIMEOUT", result.Err)
+	}
+}
@@ -24,6 +24,8 @@ func IPConfig() string {
 			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 				if ipnet.IP.To4() != nil {
 					infoBuilder.WriteString(fmt.Sprintf("Interface: %v\nIP Address: %v\n", i.Name, ipnet.IP.String()))
+				} else {
+					infoBuilder.WriteString(fmt.Sprintf("Interface: %v\nIPv6 Address: %v\n", i.Name, ipnet.IP.String()))
 				}
 			}
 		}
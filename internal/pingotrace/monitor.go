@@ -0,0 +1,182 @@
+package pingotrace
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Consecutive TTLs that never answered after which a round stops, like mtr's default --max-unknown
+const monitorMaxUnknown = 5
+
+// MonitorHeader is the header line of the table printed with MonitorHop.String.
+var MonitorHeader = fmt.Sprintf("%3s  %-45s %6s %5s %8s %8s %8s %8s %8s",
+	"Hop", "Host", "Loss%", "Sent", "Last", "Avg", "Best", "Wrst", "StDev")
+
+// MonitorHop is the running record of one TTL in MTR mode.
+type MonitorHop struct {
+	TTL   int
+	Addrs []net.IP    // Every address that answered probes with this TTL, in order of first answer
+	Names []string    // Host name of each of Addrs when TraceOptions.ResolvePTR is set, empty if unknown
+	Stats PingSummary // Loss and RTT statistics of the probes sent with this TTL
+}
+
+// Host returns the responders as "name [ip]" or "ip" separated by commas, or "???" while none answered.
+func (h MonitorHop) Host() string {
+	if len(h.Addrs) == 0 {
+		return "???"
+	}
+	hosts := make([]string, len(h.Addrs))
+	for i, addr := range h.Addrs {
+		hosts[i] = Responder{Addr: addr, Name: h.Names[i]}.Host()
+	}
+	return strings.Join(hosts, ", ")
+}
+
+// String formats the hop as one row of the table under MonitorHeader, RTTs in milliseconds.
+func (h MonitorHop) String() string {
+	ms := func(d time.Duration) string {
+		return fmt.Sprintf("%.1f", float64(d)/float64(time.Millisecond))
+	}
+	s := h.Stats
+	if s.Received == 0 {
+		return fmt.Sprintf("%3d. %-45s %5.1f%% %5d %8s %8s %8s %8s %8s",
+			h.TTL, h.Host(), s.LossPercent, s.Sent, "-", "-", "-", "-", "-")
+	}
+	return fmt.Sprintf("%3d. %-45s %5.1f%% %5d %8s %8s %8s %8s %8s",
+		h.TTL, h.Host(), s.LossPercent, s.Sent, ms(s.Last), ms(s.Avg), ms(s.Min), ms(s.Max), ms(s.Mdev))
+}
+
+// monitorHop is a MonitorHop while it is being recorded.
+type monitorHop struct {
+	MonitorHop
+	stats PingStats
+}
+
+// record adds the outcome of one probe to the hop, resolving the name of a new responder.
+func (h *monitorHop) record(ctx context.Context, probe Probe, resolvePTR bool) {
+	if probe.Reply == ProbeTimeout {
+		h.stats.Add(PingResult{Err: &PingError{Kind: PingTimeout}})
+		return
+	}
+	// Any answer counts as received, even an unreachable one, as it measures the path up to the hop
+	h.stats.Add(PingResult{From: &net.IPAddr{IP: probe.Addr}, RTT: probe.RTT})
+
+	if containsIP(h.Addrs, probe.Addr) {
+		return
+	}
+	name := ""
+	if resolvePTR {
+		if ptr, ok := PTRLookup(ctx, probe.Addr.String()); ok {
+			name = ptr
+		}
+	}
+	h.Addrs = append(h.Addrs, probe.Addr)
+	h.Names = append(h.Names, name)
+}
+
+// Monitor probes every hop on the path to target over and over, MTR style, one probe per hop and round,
+// starting a new round every interval. After each probe it sends the statistics of every hop so far to updates.
+// It runs until ctx is cancelled and closes updates when it returns.
+// A round ends at the destination, at a router reporting it unreachable, or after a few TTLs that never answered.
+// Rounds stop at the TTL the path ended at, until a round does not see that end: the path may have grown
+// after a route change, so the next round probes up to MaxHops again.
+// The options apply as to Run: up to Parallel TTLs are probed at once, Paris keeps every probe on one flow,
+// and MultiPath moves every round to the next of MaxFlows flows so that each hop lists every load-balanced router.
+func (t *Tracer) Monitor(ctx context.Context, target string, interval time.Duration, updates chan<- []MonitorHop) error {
+	defer close(updates)
+
+	tc, err := t.open(target)
+	if err != nil {
+		return err
+	}
+	defer tc.Close()
+	opts := tc.opts
+	window := max(1, opts.Parallel)
+
+	var hops []*monitorHop // Index is TTL - FirstTTL
+	lastTTL := opts.MaxHops
+	for round := 0; ; round++ {
+		roundStart := time.Now()
+		unknown := 0
+		ended := false // The round reached the destination or a router reporting it unreachable
+		flow := 0
+		if opts.MultiPath {
+			flow = round % opts.MaxFlows
+		}
+	round:
+		for first := opts.FirstTTL; first <= lastTTL; first += window {
+			// Probe a window of TTLs at once and record the answers in TTL order
+			probes := make([]Probe, min(window, lastTTL-first+1))
+			errs := make([]error, len(probes))
+			var wg sync.WaitGroup
+			for i := range probes {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					probes[i], _, errs[i] = t.probe(ctx, tc, first+i, tc.nextIndex(), flow)
+				}(i)
+			}
+			wg.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			for j, probe := range probes {
+				if errs[j] != nil {
+					return errs[j]
+				}
+				ttl := first + j
+				i := ttl - opts.FirstTTL
+				for len(hops) <= i {
+					hops = append(hops, &monitorHop{MonitorHop: MonitorHop{TTL: opts.FirstTTL + len(hops)}})
+				}
+				hops[i].record(ctx, probe, opts.ResolvePTR)
+
+				if probe.Destination() || probe.Reply == ProbeUnreachable {
+					// The path ends here, drop hops found beyond it before a route change
+					lastTTL, hops = ttl, hops[:i+1]
+					ended = true
+				}
+				select {
+				case updates <- monitorSnapshot(hops):
+				case <-ctx.Done():
+					return nil
+				}
+
+				if ended {
+					// Answers of TTLs beyond the end of the path are dropped
+					break round
+				}
+				if hops[i].stats.Summary().Received > 0 {
+					unknown = 0
+				} else if unknown++; unknown >= monitorMaxUnknown {
+					break round
+				}
+			}
+		}
+
+		if !ended {
+			lastTTL = opts.MaxHops
+		}
+
+		select {
+		case <-time.After(interval - time.Since(roundStart)):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// monitorSnapshot copies the statistics recorded so far.
+func monitorSnapshot(hops []*monitorHop) []MonitorHop {
+	snapshot := make([]MonitorHop, len(hops))
+	for i, hop := range hops {
+		snapshot[i] = hop.MonitorHop
+		snapshot[i].Stats = hop.stats.Summary()
+	}
+	return snapshot
+}
@@ -0,0 +1,62 @@
+package pingotrace
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PingOptions controls how echo requests are built and sent.
+type PingOptions struct {
+	Size         int           // Echo data size in bytes
+	Pattern      []byte        // Echo data fill pattern, repeated to Size
+	Interval     time.Duration // Wait between requests to the same target
+	Count        int           // Number of requests per target, 0 for continuous
+	Timeout      time.Duration // Wait for each reply
+	TTL          int           // IPv4 TTL or IPv6 hop limit, 0 for the system default
+	TOS          int           // IPv4 TOS or IPv6 traffic class byte; DSCP is TOS >> 2
+	DontFragment bool          // Set DF on IPv4 and disable local fragmentation on IPv6
+}
+
+// DefaultPingOptions returns the options used by the ∞ PING view: 32 bytes of data,
+// one request per second and a 4 second timeout.
+func DefaultPingOptions() PingOptions {
+	return PingOptions{
+		Size:     32,
+		Pattern:  []byte("PinGoTrace"),
+		Interval: 1 * time.Second,
+		Timeout:  4 * time.Second,
+	}
+}
+
+// Validate checks that the options are within the limits of an IP packet.
+func (o PingOptions) Validate() error {
+	switch {
+	case o.Size < 0 || o.Size > 65500:
+		return fmt.Errorf("payload size %d is out of range 0-65500", o.Size)
+	case o.Interval < 0:
+		return errors.New("interval must not be negative")
+	case o.Count < 0:
+		return errors.New("count must not be negative")
+	case o.Timeout <= 0:
+		return errors.New("timeout must be positive")
+	case o.TTL < 0 || o.TTL > 255:
+		return fmt.Errorf("TTL %d is out of range 0-255", o.TTL)
+	case o.TOS < 0 || o.TOS > 255:
+		return fmt.Errorf("TOS %d is out of range 0-255", o.TOS)
+	}
+	return nil
+}
+
+// payload returns Size bytes of echo data filled with Pattern.
+func (o PingOptions) payload() []byte {
+	data := make([]byte, o.Size)
+	pattern := o.Pattern
+	if len(pattern) == 0 {
+		pattern = []byte{0}
+	}
+	for i := range data {
+		data[i] = pattern[i%len(pattern)]
+	}
+	return data
+}
@@ -0,0 +1,134 @@
+package pingotrace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Paris traceroute keeps every field a load balancer hashes on constant across the probes of a flow:
+// the ICMP checksum for echo probes, the source and destination ports for UDP probes.
+// Probes are then told apart by a field the balancer ignores, the echo sequence number or the UDP checksum,
+// and two payload bytes are chosen so that the checksum comes out as wanted.
+
+// Distinct lengths of Paris UDP probes, which tell probes of one flow apart when their checksum is left to offload.
+// Load balancers hash on addresses and ports, not on the length.
+const parisUDPLengths = 64
+
+// mdaProbes is the number of probes after which no further next hop is expected, with 95% confidence,
+// when k distinct next hops have been seen; index k. From the Multipath Detection Algorithm
+// (Veitch, Augustin, Friedman, Teixeira: "Failure control in multipath route tracing").
+var mdaProbes = []int{0, 6, 11, 16, 21, 27, 33, 38, 44, 51, 57, 63, 70, 76, 83, 90, 96}
+
+// mdaStop returns the number of probes to send for a hop where responders distinct responders were seen.
+// A hop nobody answered gets probesPerHop probes, as in a plain trace.
+func mdaStop(responders, probesPerHop int) int {
+	if responders == 0 {
+		return probesPerHop
+	}
+	if responders < len(mdaProbes) {
+		return mdaProbes[responders]
+	}
+	return mdaProbes[len(mdaProbes)-1] + 7*(responders-len(mdaProbes)+1)
+}
+
+// onesSum adds b as big-endian 16-bit words to the ones' complement sum.
+func onesSum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// foldSum folds a ones' complement sum into 16 bits.
+func foldSum(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
+
+// checksumFiller returns the 16-bit word that, placed at an even offset of data summed to sum
+// with the word still zero, makes the Internet checksum of the data equal want.
+func checksumFiller(sum uint32, want uint16) uint16 {
+	return foldSum(uint32(^want) + uint32(^foldSum(sum)))
+}
+
+// pseudoHeader returns the IPv4 or IPv6 pseudo-header covered by UDP and ICMPv6 checksums.
+func pseudoHeader(src, dst net.IP, proto, length int) []byte {
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		b := make([]byte, 12)
+		copy(b, src4)
+		copy(b[4:], dst4)
+		b[9] = byte(proto)
+		binary.BigEndian.PutUint16(b[10:], uint16(length))
+		return b
+	}
+	b := make([]byte, 40)
+	copy(b, src.To16())
+	copy(b[16:], dst.To16())
+	binary.BigEndian.PutUint32(b[32:], uint32(length))
+	b[39] = byte(proto)
+	return b
+}
+
+// sourceIP returns the local address the system uses to reach dst.
+// No packet is sent, connecting a UDP socket only selects the route.
+func sourceIP(dst *net.IPAddr) (net.IP, error) {
+	network := "udp4"
+	if dst.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.DialUDP(network, nil, &net.UDPAddr{IP: dst.IP, Port: 9, Zone: dst.Zone})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find source address: %w", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// fillerOffset returns where the two checksum filler bytes go at the end of n bytes: the last two bytes,
+// or the two before the last one when n is odd, since the filler must be a 16-bit word of the sum.
+func fillerOffset(n int) int {
+	return (n - 2) &^ 1
+}
+
+// parisEcho sets two filler bytes at the end of the payload of the marshalled echo request b so that its
+// checksum is want. For ICMPv4 the checksum is written too; for ICMPv6 the kernel computes it
+// over the pseudo-header of src and dst.
+func parisEcho(b []byte, src, dst net.IP, want uint16) {
+	filler := fillerOffset(len(b))
+	b[2], b[3] = 0, 0
+	b[filler], b[filler+1] = 0, 0
+	var sum uint32
+	if dst.To4() == nil {
+		sum = onesSum(sum, pseudoHeader(src, dst, protocolICMPv6, len(b)))
+	}
+	sum = onesSum(sum, b)
+	binary.BigEndian.PutUint16(b[filler:], checksumFiller(sum, want))
+	if dst.To4() != nil {
+		binary.BigEndian.PutUint16(b[2:], want)
+	}
+}
+
+// parisUDPPayload returns data followed by two filler bytes as payload for a UDP datagram
+// from srcPort to dstPort whose checksum will be want. When the payload length is odd, the filler
+// overwrites the last data byte so that it stays 16-bit aligned.
+func parisUDPPayload(data []byte, src, dst net.IP, srcPort, dstPort int, want uint16) []byte {
+	payload := append(append([]byte(nil), data...), 0, 0)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint16(header[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(header[2:], uint16(dstPort))
+	binary.BigEndian.PutUint16(header[4:], uint16(len(header)+len(payload)))
+
+	filler := fillerOffset(len(payload)) // The UDP header is 8 bytes, so this is aligned in the datagram too
+	payload[filler], payload[filler+1] = 0, 0
+	sum := onesSum(0, pseudoHeader(src, dst, protocolUDP, len(header)+len(payload)))
+	sum = onesSum(sum, header)
+	sum = onesSum(sum, payload)
+	binary.BigEndian.PutUint16(payload[filler:], checksumFiller(sum, want))
+	return payload
+}
@@ -0,0 +1,115 @@
+package pingotrace
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// internetChecksum computes the RFC 1071 checksum of the concatenated parts the plain way,
+// independently of the helpers under test.
+func internetChecksum(parts ...[]byte) uint16 {
+	var all []byte
+	for _, part := range parts {
+		all = append(all, part...)
+	}
+	if len(all)%2 == 1 {
+		all = append(all, 0)
+	}
+	var sum uint32
+	for i := 0; i < len(all); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(all[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+var parisAddresses = []struct {
+	name     string
+	src, dst net.IP
+}{
+	{"IPv4", net.ParseIP("192.0.2.10"), net.ParseIP("198.51.100.7")},
+	{"IPv6", net.ParseIP("2001:db8::10"), net.ParseIP("2001:db8:1::7")},
+}
+
+func TestParisUDPPayloadChecksum(t *testing.T) {
+	const srcPort, dstPort = 40000, 33434
+	for _, addrs := range parisAddresses {
+		for _, size := range []int{0, 10, 11} {
+			// Every padding probeUDP uses, odd lengths included
+			for index := range parisUDPLengths {
+				want := uint16(index + 1)
+				data := append(TraceOptions{Size: size}.payload(), make([]byte, index)...)
+				payload := parisUDPPayload(data, addrs.src, addrs.dst, srcPort, dstPort, want)
+				if len(payload) != len(data)+2 {
+					t.Fatalf("%s size %d index %d: payload length %d, want %d", addrs.name, size, index, len(payload), len(data)+2)
+				}
+
+				header := make([]byte, 8)
+				binary.BigEndian.PutUint16(header[0:], srcPort)
+				binary.BigEndian.PutUint16(header[2:], dstPort)
+				binary.BigEndian.PutUint16(header[4:], uint16(8+len(payload)))
+				got := internetChecksum(pseudoHeader(addrs.src, addrs.dst, protocolUDP, 8+len(payload)), header, payload)
+				if got != want {
+					t.Errorf("%s size %d index %d: UDP checksum %d, want %d", addrs.name, size, index, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestParisEchoChecksum(t *testing.T) {
+	const want = 0x1234
+	for _, addrs := range parisAddresses {
+		for size := 0; size <= 20; size++ {
+			var echoType icmp.Type = ipv4.ICMPTypeEcho
+			if addrs.dst.To4() == nil {
+				echoType = ipv6.ICMPTypeEchoRequest
+			}
+			// As probeICMP builds it: the data followed by the filler
+			message := icmp.Message{Type: echoType, Body: &icmp.Echo{ID: 7, Seq: size, Data: append(TraceOptions{Size: size}.payload(), 0, 0)}}
+			b, err := message.Marshal(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parisEcho(b, addrs.src, addrs.dst, want)
+
+			var got uint16
+			if addrs.dst.To4() != nil {
+				if stored := binary.BigEndian.Uint16(b[2:]); stored != want {
+					t.Errorf("%s size %d: stored checksum %#04x, want %#04x", addrs.name, size, stored, want)
+				}
+				zeroed := append([]byte(nil), b...)
+				zeroed[2], zeroed[3] = 0, 0
+				got = internetChecksum(zeroed)
+			} else {
+				// The kernel computes the ICMPv6 checksum over the pseudo-header
+				got = internetChecksum(pseudoHeader(addrs.src, addrs.dst, protocolICMPv6, len(b)), b)
+			}
+			if got != want {
+				t.Errorf("%s size %d: echo checksum %#04x, want %#04x", addrs.name, size, got, want)
+			}
+		}
+	}
+}
+
+func TestMDAStop(t *testing.T) {
+	tests := []struct{ responders, probesPerHop, want int }{
+		{0, 3, 3},
+		{1, 3, 6},
+		{2, 3, 11},
+		{16, 3, 96},
+		{17, 3, 103},
+	}
+	for _, tt := range tests {
+		if got := mdaStop(tt.responders, tt.probesPerHop); got != tt.want {
+			t.Errorf("mdaStop(%d, %d) = %d, want %d", tt.responders, tt.probesPerHop, got, tt.want)
+		}
+	}
+}
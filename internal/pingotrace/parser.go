@@ -2,11 +2,15 @@ package pingotrace
 
 import (
 	"fmt"
+	"net"
 	"regexp"
 	"strings"
 )
 
-// Hostname + Hostname.domain + ipv4 parser without duplicates - subnet masks - wildcard mask
+// Hostname + Hostname.domain + ipv4 + ipv6 parser without duplicates - subnet masks - wildcard mask
+// A "v4:" or "v6:" prefix on a substring is kept on the parsed target as its address family preference,
+// and a ":port" suffix, e.g. "example.com:443" or "[2001:db8::1]:443", as the port of a TCP connect ping.
+// http and https URLs are kept whole, path included, for HTTP probes.
 func ParseInput(text string) interface{} {
 	// Define the regex pattern
 	hostnameWithDomainsPattern := `^(?:https?:\/\/)?([a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*\.[a-zA-Z]{2,63})(?:\/|$)`
@@ -41,17 +45,49 @@ func ParseInput(text string) interface{} {
 	for _, substring := range substrings {
 		// Clean the substring of extra characters
 		cleanedSubstring := strings.Trim(substring, `" ,`)
+		// Split off the address family preference, if any
+		cleanedSubstring, family := SplitFamily(cleanedSubstring)
+
+		// Keep URLs whole for HTTP probes
+		if u := ParseURL(cleanedSubstring); u != nil {
+			host := u.Hostname()
+			if net.ParseIP(host) != nil || hostnameWithDomainsRegex.MatchString(host) || hostnameRegex.FindString(host) == host {
+				u.Fragment = ""
+				target := WithFamily(u.String(), family)
+				if !addedElements[target] {
+					uniqueMatchesSlice = append(uniqueMatchesSlice, target)
+					addedElements[target] = true
+				}
+			}
+			continue
+		}
+
+		// Split off the TCP port, if any
+		cleanedSubstring, port := SplitPort(cleanedSubstring)
 
 		// Check against subnet mask pattern, and continue if matched
 		if subnetMaskRegex.MatchString(cleanedSubstring) {
 			continue
 		}
 
+		// Check against IPv6 before IPv4, as IPv4-mapped IPv6 addresses contain an IPv4
+		if ipv6 := strings.Trim(cleanedSubstring, "[]"); strings.Contains(ipv6, ":") && net.ParseIP(ipv6) != nil {
+			ipv6 = WithFamily(JoinPort(ipv6, port), family)
+			if !addedElements[ipv6] {
+				uniqueMatchesSlice = append(uniqueMatchesSlice, ipv6)
+				addedElements[ipv6] = true
+			}
+			continue
+		}
+
 		// // Check against IPv4
 		ipv4 := ParseIPv4(cleanedSubstring)
-		if ipv4 != "" && !addedElements[ipv4] {
-			uniqueMatchesSlice = append(uniqueMatchesSlice, ipv4)
-			addedElements[ipv4] = true
+		if ipv4 != "" {
+			ipv4 = WithFamily(JoinPort(ipv4, port), family)
+			if !addedElements[ipv4] {
+				uniqueMatchesSlice = append(uniqueMatchesSlice, ipv4)
+				addedElements[ipv4] = true
+			}
 			// You may or may not need "continue" here, depending on the rest of your logic.
 			continue
 		}
@@ -59,15 +95,19 @@ func ParseInput(text string) interface{} {
 		// Check against hostname with domain pattern
 		match := hostnameWithDomainsRegex.FindStringSubmatch(cleanedSubstring)
 		if len(match) > 1 {
-			if !addedElements[match[1]] {
-				uniqueMatchesSlice = append(uniqueMatchesSlice, match[1])
-				addedElements[match[1]] = true
+			host := WithFamily(JoinPort(match[1], port), family)
+			if !addedElements[host] {
+				uniqueMatchesSlice = append(uniqueMatchesSlice, host)
+				addedElements[host] = true
 				// fmt.Println(addedElements)
 				continue
 			}
-		} else if hostnameRegex.MatchString(cleanedSubstring) && !addedElements[cleanedSubstring] {
-			uniqueMatchesSlice = append(uniqueMatchesSlice, cleanedSubstring)
-			addedElements[cleanedSubstring] = true
+		} else if hostnameRegex.MatchString(cleanedSubstring) {
+			host := WithFamily(JoinPort(cleanedSubstring, port), family)
+			if !addedElements[host] {
+				uniqueMatchesSlice = append(uniqueMatchesSlice, host)
+				addedElements[host] = true
+			}
 			// continue
 		}
 	}
@@ -100,7 +140,7 @@ func ParseIPv4(text string) string {
 	return ""
 }
 
-// CheckIPv6 checks if a string is a valid IPv4 address.
+// CheckIPv6 checks if a string is a valid IPv6 address.
 func CheckIPv6(ip string) bool {
 	pattern := `^(([0-9a-fA-F]{1,4}:){7,7}[0-9a-fA-F]{1,4}|([0-9a-fA-F]{1,4}:){1,7}:|([0-9a-fA-F]{1,4}:){1,6}:[0-9a-fA-F]{1,4}|([0-9a-fA-F]{1,4}:){1,5}(:[0-9a-fA-F]{1,4}){1,2}|([0-9a-fA-F]{1,4}:){1,4}(:[0-9a-fA-F]{1,4}){1,3}|([0-9a-fA-F]{1,4}:){1,3}(:[0-9a-fA-F]{1,4}){1,4}|([0-9a-fA-F]{1,4}:){1,2}(:[0-9a-fA-F]{1,4}){1,5}|[0-9a-fA-F]{1,4}:((:[0-9a-fA-F]{1,4}){1,6})|:((:[0-9a-fA-F]{1,4}){1,7}|:)|fe80:(:[0-9a-fA-F]{0,4}){0,4}%[0-9a-zA-Z]{1,}|::(ffff(:0{1,4}){0,1}:){0,1}((25[0-5]|(2[0-4]|1{0,1}[0-9]){0,1}[0-9])\.){3,3}(25[0-5]|(2[0-4]|1{0,1}[0-9]){0,1}[0-9])|([0-9a-fA-F]{1,4}:){1,4}:((25[0-5]|(2[0-4]|1{0,1}[0-9]){0,1}[0-9])\.){3,3}(25[0-5]|(2[0-4]|1{0,1}[0-9]){0,1}[0-9]))$`
 	matched, _ := regexp.MatchString(pattern, ip)
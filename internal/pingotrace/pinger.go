@@ -0,0 +1,377 @@
+package pingotrace
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMPv4 = 1
+	protocolICMPv6 = 58
+)
+
+// Time a socket opened for non-default ping options stays open unused, since every open raw socket
+// receives a copy of every ICMP message
+const optionSocketIdle = 10 * time.Second
+
+// Pinger is a long-lived ICMP echo engine. It owns one IPv4 and one IPv6 raw socket
+// and routes every reply to the waiting caller by echo ID and sequence number,
+// so any number of goroutines can ping concurrently without opening sockets per probe.
+// Requests with a non-default TTL, TOS or DF setting get an extra socket per distinct option set,
+// closed once it has not been used for optionSocketIdle.
+type Pinger struct {
+	id  int          // Echo ID shared by every probe sent from this Pinger
+	seq atomic.Int32 // Echo sequence counter, wraps at 16 bits
+
+	mu      sync.Mutex
+	sockets map[socketKey]*pingerSocket
+	pending map[pingKey]chan pingReply // Callers waiting for a reply
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// pingerSocket is an open socket of a Pinger with what it takes to tell when it is no longer used.
+type pingerSocket struct {
+	*icmpSocket
+	users    int         // Pings in flight on the socket
+	lastUsed time.Time   // When the last ping on the socket finished
+	idle     *time.Timer // Closes a socket of non-default options once unused for optionSocketIdle
+}
+
+// pingKey identifies a single outstanding echo request.
+type pingKey struct {
+	proto int
+	id    int
+	seq   int
+}
+
+// pingReply is an ICMP message that was matched to an outstanding echo request.
+type pingReply struct {
+	message  *icmp.Message
+	raw      []byte // Whole ICMP message as received
+	peer     net.Addr
+	ttl      int // TTL or hop limit of the received packet, 0 if unknown
+	received time.Time
+}
+
+// NewPinger opens the IPv4 and IPv6 ICMP sockets and starts their read loops.
+// A missing IPv6 stack is not an error; IPv6 pings then fail individually.
+func NewPinger() (*Pinger, error) {
+	p := &Pinger{
+		id:      os.Getpid() & 0xffff,
+		sockets: make(map[socketKey]*pingerSocket),
+		pending: make(map[pingKey]chan pingReply),
+		done:    make(chan struct{}),
+	}
+
+	for _, proto := range []int{protocolICMPv4, protocolICMPv6} {
+		key := socketKey{proto: proto}
+		if _, err := p.socket(key); err != nil {
+			if proto == protocolICMPv4 {
+				return nil, err
+			}
+			continue
+		}
+		p.release(key)
+	}
+	return p, nil
+}
+
+// Close shuts down all sockets. Callers still waiting for a reply fail with PingSocketError.
+func (p *Pinger) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, socket := range p.sockets {
+			if socket.idle != nil {
+				socket.idle.Stop()
+			}
+			socket.Close()
+		}
+	})
+	return nil
+}
+
+// socket returns the socket for key, opening it and starting its read loop on first use.
+// The caller must release the socket when done with it.
+func (p *Pinger) socket(key socketKey) (*icmpSocket, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil, errors.New("pinger closed")
+	default:
+	}
+
+	if socket, ok := p.sockets[key]; ok {
+		socket.users++
+		if socket.idle != nil {
+			socket.idle.Stop()
+		}
+		return socket.icmpSocket, nil
+	}
+	socket, err := listenICMP(key)
+	if err != nil {
+		return nil, err
+	}
+	p.sockets[key] = &pingerSocket{icmpSocket: socket, users: 1}
+	go p.readLoop(socket)
+	return socket, nil
+}
+
+// release ends a use of the socket for key. A socket of non-default options is closed once nobody
+// has used it for optionSocketIdle; the default sockets stay open until Close.
+func (p *Pinger) release(key socketKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	socket, ok := p.sockets[key]
+	if !ok {
+		return
+	}
+	socket.users--
+	socket.lastUsed = time.Now()
+	if socket.users > 0 || key == (socketKey{proto: key.proto}) {
+		return
+	}
+	if socket.idle == nil {
+		socket.idle = time.AfterFunc(optionSocketIdle, func() { p.closeIdle(key) })
+	} else {
+		socket.idle.Reset(optionSocketIdle)
+	}
+}
+
+// closeIdle closes the socket for key if it is still unused since optionSocketIdle.
+func (p *Pinger) closeIdle(key socketKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// The socket may have been used again while the timer fired
+	if socket, ok := p.sockets[key]; ok && socket.users == 0 && time.Since(socket.lastUsed) >= optionSocketIdle {
+		socket.Close()
+		delete(p.sockets, key)
+	}
+}
+
+// Ping sends a single ICMP echo request built from opts to ipAddr and waits up to opts.Timeout for the matching reply.
+// Timeouts, socket errors and ICMP errors are reported through PingResult.Err as a *PingError.
+func (p *Pinger) Ping(ctx context.Context, ipAddr *net.IPAddr, opts PingOptions) PingResult {
+	proto := protocolICMPv4
+	var echoType icmp.Type = ipv4.ICMPTypeEcho
+	if ipAddr.IP.To4() == nil {
+		proto = protocolICMPv6
+		echoType = ipv6.ICMPTypeEchoRequest
+	}
+
+	key := pingKey{proto: proto, id: p.id, seq: p.nextSeq()}
+	result := PingResult{Addr: ipAddr, Seq: key.seq}
+
+	if err := opts.Validate(); err != nil {
+		result.Err = &PingError{Kind: PingSocketError, Err: err}
+		return result
+	}
+	socket, err := p.socket(opts.key(proto))
+	if err != nil {
+		result.Err = &PingError{Kind: PingSocketError, Err: err}
+		return result
+	}
+	defer p.release(opts.key(proto))
+
+	replyChan := p.register(key)
+	defer p.unregister(key)
+
+	message := icmp.Message{
+		Type: echoType, Code: 0,
+		Body: &icmp.Echo{
+			ID:   key.id,
+			Seq:  key.seq,
+			Data: opts.payload(),
+		},
+	}
+	b, err := message.Marshal(nil)
+	if err != nil {
+		result.Err = &PingError{Kind: PingSocketError, Err: err}
+		return result
+	}
+
+	startTime := time.Now()
+	if _, err := socket.WriteTo(b, ipAddr); err != nil {
+		result.Err = &PingError{Kind: PingSocketError, Err: err}
+		return result
+	}
+
+	timer := time.NewTimer(opts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-replyChan:
+		result.From = reply.peer
+		result.TTL = reply.ttl
+		if echoReply, ok := reply.message.Body.(*icmp.Echo); ok {
+			// Adding nanosecond to duration as some Ping tests were returning 0ms RTT
+			result.RTT = reply.received.Sub(startTime) + time.Nanosecond
+			result.Size = len(echoReply.Data)
+			return result
+		}
+		result.Err = icmpError(reply.message, reply.raw, reply.peer)
+	case <-timer.C:
+		result.Err = &PingError{Kind: PingTimeout}
+	case <-ctx.Done():
+		result.Err = &PingError{Kind: PingTimeout, Err: ctx.Err()}
+	case <-p.done:
+		result.Err = &PingError{Kind: PingSocketError, Err: errors.New("pinger closed")}
+	}
+	return result
+}
+
+// nextSeq returns the next 16-bit echo sequence number.
+func (p *Pinger) nextSeq() int {
+	return int(p.seq.Add(1)) & 0xffff
+}
+
+// register adds a waiting caller for the given probe.
+func (p *Pinger) register(key pingKey) chan pingReply {
+	replyChan := make(chan pingReply, 1)
+	p.mu.Lock()
+	p.pending[key] = replyChan
+	p.mu.Unlock()
+	return replyChan
+}
+
+// unregister removes a waiting caller once it has its answer or gave up.
+func (p *Pinger) unregister(key pingKey) {
+	p.mu.Lock()
+	delete(p.pending, key)
+	p.mu.Unlock()
+}
+
+// readLoop reads every ICMP message arriving on socket and hands it to the caller that sent the probe.
+// Echo replies are matched by their own ID/sequence, error messages by the echo header they quote.
+// Raw sockets all receive a copy of every message, so only the first copy reaches the caller.
+func (p *Pinger) readLoop(socket *icmpSocket) {
+	proto := socket.proto
+	buf := make([]byte, 65536)
+	for {
+		n, ttl, peer, err := socket.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		received := time.Now()
+
+		message, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+
+		id, seq, ok := echoIdentity(proto, message)
+		if !ok {
+			continue
+		}
+
+		key := pingKey{proto: proto, id: id, seq: seq}
+		p.mu.Lock()
+		replyChan, ok := p.pending[key]
+		p.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		// The buffered channel only ever needs the first answer
+		select {
+		case replyChan <- pingReply{message: message, raw: append([]byte(nil), buf[:n]...), peer: peer, ttl: ttl, received: received}:
+		default:
+		}
+	}
+}
+
+// echoIdentity returns the echo ID and sequence a received ICMP message belongs to.
+// For echo replies these are read directly; for Destination Unreachable, Time Exceeded
+// and Parameter Problem they are read from the quoted original datagram.
+func echoIdentity(proto int, message *icmp.Message) (int, int, bool) {
+	if body, ok := message.Body.(*icmp.Echo); ok {
+		if message.Type == ipv4.ICMPTypeEchoReply || message.Type == ipv6.ICMPTypeEchoReply {
+			return body.ID, body.Seq, true
+		}
+		return 0, 0, false
+	}
+	if data, ok := quotedDatagram(message); ok {
+		return quotedEcho(proto, data)
+	}
+	return 0, 0, false
+}
+
+// quotedDatagram returns the original datagram quoted in an ICMP error message.
+func quotedDatagram(message *icmp.Message) ([]byte, bool) {
+	switch body := message.Body.(type) {
+	case *icmp.DstUnreach:
+		return body.Data, true
+	case *icmp.TimeExceeded:
+		return body.Data, true
+	case *icmp.ParamProb:
+		return body.Data, true
+	case *icmp.PacketTooBig:
+		return body.Data, true
+	}
+	return nil, false
+}
+
+// quotedEcho decodes the IP header and first 8 bytes of ICMP quoted in an ICMP error message
+// and returns the echo ID and sequence of the original request.
+func quotedEcho(proto int, data []byte) (int, int, bool) {
+	var icmpHeader []byte
+	switch proto {
+	case protocolICMPv4:
+		if len(data) < ipv4.HeaderLen || data[0]>>4 != 4 {
+			return 0, 0, false
+		}
+		headerLen := int(data[0]&0x0f) << 2
+		if data[9] != protocolICMPv4 || len(data) < headerLen+8 {
+			return 0, 0, false
+		}
+		icmpHeader = data[headerLen : headerLen+8]
+		if icmpHeader[0] != byte(ipv4.ICMPTypeEcho) {
+			return 0, 0, false
+		}
+	case protocolICMPv6:
+		if len(data) < ipv6.HeaderLen+8 || data[0]>>4 != 6 || data[6] != protocolICMPv6 {
+			return 0, 0, false
+		}
+		icmpHeader = data[ipv6.HeaderLen : ipv6.HeaderLen+8]
+		if icmpHeader[0] != byte(ipv6.ICMPTypeEchoRequest) {
+			return 0, 0, false
+		}
+	default:
+		return 0, 0, false
+	}
+	id := int(icmpHeader[4])<<8 | int(icmpHeader[5])
+	seq := int(icmpHeader[6])<<8 | int(icmpHeader[7])
+	return id, seq, true
+}
+
+// Shared Pinger used by Ping, opened on first use
+var (
+	defaultPinger     *Pinger
+	defaultPingerErr  error
+	defaultPingerOnce sync.Once
+)
+
+// DefaultPinger returns the process-wide Pinger, opening its sockets on first use.
+func DefaultPinger() (*Pinger, error) {
+	defaultPingerOnce.Do(func() {
+		defaultPinger, defaultPingerErr = NewPinger()
+	})
+	return defaultPinger, defaultPingerErr
+}
@@ -0,0 +1,193 @@
+package pingotrace
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// PingResult is the outcome of a single echo request or TCP connect ping.
+type PingResult struct {
+	Addr *net.IPAddr   // Address the request was sent to
+	From net.Addr      // Address that answered, either the target or a router reporting an error
+	Seq  int           // Echo sequence number of the request
+	RTT  time.Duration // Round-trip time, zero when Err is set
+	TTL  int           // TTL (IPv4) or hop limit (IPv6) of the reply, 0 if unknown
+	Size int           // Size of the echo data in the reply
+	Port int           // TCP port of a TCP connect ping, 0 for an echo request
+	Err  error         // Nil on success, usually a *PingError otherwise
+}
+
+// Success reports whether an echo reply was received.
+func (r PingResult) Success() bool {
+	return r.Err == nil
+}
+
+// Label returns a short status for grid display: "12 ms", "< 1 ms" or an error label such as "H-UNR".
+func (r PingResult) Label() string {
+	if r.Err != nil {
+		if pingErr, ok := r.Err.(*PingError); ok {
+			return pingErr.Kind.Label()
+		}
+		return "ERROR"
+	}
+	if r.RTT < 500*time.Microsecond { // Less than 0.5 ms
+		return "< 1 ms"
+	}
+	return fmt.Sprintf("%.0f ms", float64(r.RTT)/float64(time.Millisecond))
+}
+
+// PingErrorKind classifies why an echo request, TCP connect ping or HTTP probe failed.
+type PingErrorKind int
+
+const (
+	PingTimeout PingErrorKind = iota + 1
+	PingNetUnreachable
+	PingHostUnreachable
+	PingProtocolUnreachable
+	PingPortUnreachable
+	PingFragmentationNeeded
+	PingSourceRouteFailed
+	PingAdminProhibited
+	PingUnreachable
+	PingTimeExceeded
+	PingParameterProblem
+	PingSocketError
+	PingRefused   // The TCP port answered with a reset: the host is up, the port closed
+	PingFiltered  // The TCP port did not answer: a firewall drops the connection attempt
+	PingTLSError  // The TLS handshake of an HTTPS probe failed, e.g. on an invalid certificate
+	PingHTTPError // An HTTP probe was answered with a 4xx or 5xx status code
+)
+
+// pingErrorKindNames holds the long description and grid label for each kind
+var pingErrorKindNames = map[PingErrorKind][2]string{
+	PingTimeout:             {"request timed out", "TIMEOUT"},
+	PingNetUnreachable:      {"destination net unreachable", "N-UNR"},
+	PingHostUnreachable:     {"destination host unreachable", "H-UNR"},
+	PingProtocolUnreachable: {"destination protocol unreachable", "P-UNR"},
+	PingPortUnreachable:     {"destination port unreachable", "PORT-UNR"},
+	PingFragmentationNeeded: {"fragmentation needed and DF set", "FRAG-N"},
+	PingSourceRouteFailed:   {"source route failed", "SR-FAIL"},
+	PingAdminProhibited:     {"communication administratively prohibited", "ADMIN"},
+	PingUnreachable:         {"destination unreachable", "UNR"},
+	PingTimeExceeded:        {"TTL expired in transit", "TTL-EXP"},
+	PingParameterProblem:    {"parameter problem", "PARAM"},
+	PingSocketError:         {"socket error", "ERROR"},
+	PingRefused:             {"connection refused", "REFUSED"},
+	PingFiltered:            {"connection timed out, port filtered", "FILTERED"},
+	PingTLSError:            {"TLS handshake failed", "TLS-ERR"},
+	PingHTTPError:           {"HTTP error status", "HTTP"},
+}
+
+// String returns the long description of the error kind.
+func (k PingErrorKind) String() string {
+	if names, ok := pingErrorKindNames[k]; ok {
+		return names[0]
+	}
+	return fmt.Sprintf("ping error %d", int(k))
+}
+
+// Label returns the short grid label of the error kind.
+func (k PingErrorKind) Label() string {
+	if names, ok := pingErrorKindNames[k]; ok {
+		return names[1]
+	}
+	return "ERROR"
+}
+
+// PingError describes a failed echo request.
+type PingError struct {
+	Kind PingErrorKind
+	From net.Addr // Router or host that sent the ICMP error, nil for timeouts and socket errors
+	Type int      // ICMP type of the error message
+	Code int      // ICMP code of the error message, or HTTP status code with PingHTTPError
+	MTU  int      // Next-hop MTU reported with PingFragmentationNeeded, 0 if not reported
+	Err  error    // Underlying socket error for PingSocketError
+}
+
+func (e *PingError) Error() string {
+	msg := e.Kind.String()
+	if e.Kind == PingFragmentationNeeded && e.MTU > 0 {
+		msg = fmt.Sprintf("%s (next-hop MTU %d)", msg, e.MTU)
+	}
+	if e.Kind == PingUnreachable {
+		msg = fmt.Sprintf("%s with code %d", msg, e.Code)
+	}
+	if e.Kind == PingHTTPError {
+		msg = fmt.Sprintf("%s %d", msg, e.Code)
+	}
+	if e.From != nil {
+		msg = fmt.Sprintf("%s, reported by %s", msg, e.From)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *PingError) Unwrap() error {
+	return e.Err
+}
+
+// icmpError converts an ICMP error message received in answer to an echo request into a *PingError.
+// raw is the whole ICMP message, used for fields that icmp.ParseMessage does not expose.
+func icmpError(message *icmp.Message, raw []byte, from net.Addr) *PingError {
+	pingErr := &PingError{Kind: PingUnreachable, From: from, Code: message.Code}
+
+	switch message.Type {
+	case ipv4.ICMPTypeDestinationUnreachable:
+		switch message.Code {
+		case 0, 6, 11: // Net unreachable, net unknown, net unreachable for TOS
+			pingErr.Kind = PingNetUnreachable
+		case 1, 7, 12: // Host unreachable, host unknown, host unreachable for TOS
+			pingErr.Kind = PingHostUnreachable
+		case 2:
+			pingErr.Kind = PingProtocolUnreachable
+		case 3:
+			pingErr.Kind = PingPortUnreachable
+		case 4:
+			pingErr.Kind = PingFragmentationNeeded
+			// RFC 1191: next-hop MTU is carried in the low 16 bits of the second header word
+			if len(raw) >= 8 {
+				pingErr.MTU = int(raw[6])<<8 | int(raw[7])
+			}
+		case 5:
+			pingErr.Kind = PingSourceRouteFailed
+		case 9, 10, 13: // Net, host or communication administratively prohibited
+			pingErr.Kind = PingAdminProhibited
+		}
+	case ipv6.ICMPTypeDestinationUnreachable:
+		switch message.Code {
+		case 0, 6: // No route to destination, reject route
+			pingErr.Kind = PingNetUnreachable
+		case 1, 5: // Administratively prohibited, source address failed policy
+			pingErr.Kind = PingAdminProhibited
+		case 3:
+			pingErr.Kind = PingHostUnreachable
+		case 4:
+			pingErr.Kind = PingPortUnreachable
+		}
+	case ipv6.ICMPTypePacketTooBig:
+		pingErr.Kind = PingFragmentationNeeded
+		if body, ok := message.Body.(*icmp.PacketTooBig); ok {
+			pingErr.MTU = body.MTU
+		}
+	case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded:
+		pingErr.Kind = PingTimeExceeded
+	case ipv4.ICMPTypeParameterProblem, ipv6.ICMPTypeParameterProblem:
+		pingErr.Kind = PingParameterProblem
+	}
+
+	// Record the numeric type for every ICMP version
+	switch t := message.Type.(type) {
+	case ipv4.ICMPType:
+		pingErr.Type = int(t)
+	case ipv6.ICMPType:
+		pingErr.Type = int(t)
+	}
+	return pingErr
+}
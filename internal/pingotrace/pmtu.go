@@ -0,0 +1,204 @@
+package pingotrace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Bounds of the path MTU search, as IP packet sizes including headers.
+// Every IPv4 link carries 68 bytes and every IPv6 link 1280; sizes above jumbo frames are not tried.
+const (
+	pmtuMinIPv4 = 68
+	pmtuMinIPv6 = 1280
+	pmtuMax     = 9216
+	pmtuCommon  = 1500 // Ethernet MTU, tried first
+	pmtuRetries = 2    // Echo requests per size before a silent size counts as too big
+)
+
+// PMTUResult is the outcome of a path MTU discovery.
+type PMTUResult struct {
+	Addr    *net.IPAddr
+	MTU     int  // Largest IP packet, headers included, that reached the destination without fragmentation
+	Capped  bool // MTU is the largest size tried, the path may carry more
+	Headers int  // Bytes of IP and ICMP headers in MTU; MTU - Headers is the largest echo data size
+	Probes  int  // Echo requests sent by the search
+
+	ReportedMTU int      // Next-hop MTU of the last Fragmentation Needed or Packet Too Big received, 0 if none
+	ReportedBy  net.Addr // Router that sent ReportedMTU
+
+	Hops       []Hop // Trace with packets one byte over MTU, up to the hop that stopped them
+	Bottleneck *Hop  // Hop where the MTU shrinks, nil if it could not be located
+	BlackHole  bool  // Packets over MTU vanish after Bottleneck without an ICMP error
+	Local      bool  // The interface of this host limits the MTU
+}
+
+// DiscoverPMTU finds the path MTU to target by binary search with DF echo requests,
+// jumping to the next-hop MTU whenever a router reports one. When the path is narrower than
+// the largest size tried, it then traces the path with packets one byte over the MTU to locate
+// the hop where the MTU shrinks. Trace options such as the timeout and the probe method are those of t.
+func (t *Tracer) DiscoverPMTU(ctx context.Context, target string) (PMTUResult, error) {
+	ipAddr, err := ResolveTarget(target)
+	if err != nil {
+		return PMTUResult{}, fmt.Errorf("unable to resolve destination IP address: %w", err)
+	}
+	if t.Options.Timeout <= 0 {
+		return PMTUResult{}, errors.New("timeout must be positive")
+	}
+	pinger, err := DefaultPinger()
+	if err != nil {
+		return PMTUResult{}, err
+	}
+
+	result := PMTUResult{Addr: ipAddr, Headers: 20 + 8}
+	low := pmtuMinIPv4
+	if ipAddr.IP.To4() == nil {
+		result.Headers, low = 40+8, pmtuMinIPv6
+	}
+	high := pmtuMax
+
+	// fits sends up to pmtuRetries echo requests of size bytes and reports whether one was answered.
+	// Too big is told by a router, by the local stack, or by silence.
+	fits := func(size int) (bool, error) {
+		opts := PingOptions{
+			Size:         size - result.Headers,
+			Pattern:      []byte("PinGoTrace"),
+			Timeout:      t.Options.Timeout,
+			DontFragment: true,
+		}
+		for try := 0; try < pmtuRetries; try++ {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+			result.Probes++
+			reply := pinger.Ping(ctx, ipAddr, opts)
+			if reply.Success() {
+				return true, nil
+			}
+			var pingErr *PingError
+			if !errors.As(reply.Err, &pingErr) {
+				return false, reply.Err
+			}
+			switch pingErr.Kind {
+			case PingTimeout:
+				continue
+			case PingFragmentationNeeded:
+				if pingErr.MTU > 0 {
+					result.ReportedMTU, result.ReportedBy = pingErr.MTU, reply.From
+					high = min(high, pingErr.MTU)
+				}
+				return false, nil
+			case PingSocketError:
+				if isMessageTooLong(pingErr.Err) {
+					return false, nil
+				}
+			}
+			return false, fmt.Errorf("echo request of %d bytes failed: %w", size, pingErr)
+		}
+		return false, ctx.Err()
+	}
+
+	ok, err := fits(low)
+	if err != nil {
+		return result, err
+	}
+	if !ok {
+		return result, fmt.Errorf("%s does not answer echo requests of the minimum size of %d bytes", ipAddr, low)
+	}
+	tried := map[int]bool{low: true}
+	for low < high {
+		size := (low + high + 1) / 2
+		switch {
+		case result.ReportedMTU > low && result.ReportedMTU <= high && !tried[result.ReportedMTU]:
+			size = result.ReportedMTU
+		case low < pmtuCommon && pmtuCommon <= high && !tried[pmtuCommon]:
+			size = pmtuCommon
+		}
+		tried[size] = true
+		ok, err := fits(size)
+		if err != nil {
+			return result, err
+		}
+		if ok {
+			low = size
+		} else {
+			high = min(high, size-1)
+		}
+	}
+	result.MTU, result.Capped = low, low == pmtuMax
+	if result.Capped {
+		return result, nil
+	}
+	return result, t.locateBottleneck(ctx, target, &result)
+}
+
+// locateBottleneck traces the path with DF packets one byte over the path MTU.
+// They pass every hop before the narrow link; the hop in front of it answers with Fragmentation Needed,
+// or, in a black hole, the trace falls silent after it.
+func (t *Tracer) locateBottleneck(ctx context.Context, target string, result *PMTUResult) error {
+	opts := t.Options
+	if opts.Method == TraceTCP {
+		// A SYN carries no data to make it larger
+		opts.Method = TraceICMP
+	}
+	opts.Paris, opts.MultiPath = false, false
+	opts.Size = result.MTU + 1 - result.Headers // UDP and ICMP echo headers are both 8 bytes
+	opts.DontFragment = true
+
+	hops := make(chan Hop)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- NewTracer(opts).Run(ctx, target, hops)
+	}()
+	for hop := range hops {
+		result.Hops = append(result.Hops, hop)
+	}
+	if err := <-errChan; err != nil {
+		if isMessageTooLong(err) {
+			result.Local = true
+			return nil
+		}
+		return err
+	}
+
+	lastAnswered := -1
+	for i, hop := range result.Hops {
+		for _, probe := range hop.Probes {
+			if probe.Reply == ProbeUnreachable && probe.Err.Kind == PingFragmentationNeeded {
+				// The router answered the previous TTL with Time Exceeded before trying to forward,
+				// so it is listed at the hop it was first seen
+				result.Bottleneck = &result.Hops[i]
+				for j := range result.Hops[:i] {
+					if containsResponder(result.Hops[j], probe.Addr) {
+						result.Bottleneck = &result.Hops[j]
+						break
+					}
+				}
+				return nil
+			}
+		}
+		if hop.Reached {
+			// Larger packets got through after all, the path changed or the search saw losses
+			return nil
+		}
+		if len(hop.Responders) > 0 {
+			lastAnswered = i
+		}
+	}
+	if lastAnswered >= 0 {
+		result.Bottleneck, result.BlackHole = &result.Hops[lastAnswered], true
+		result.Hops = result.Hops[:lastAnswered+1]
+	}
+	return nil
+}
+
+// containsResponder reports whether addr answered any probe of hop.
+func containsResponder(hop Hop, addr net.IP) bool {
+	for _, responder := range hop.Responders {
+		if responder.Addr.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
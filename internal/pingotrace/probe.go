@@ -0,0 +1,248 @@
+package pingotrace
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// probe sends the index-th probe of the run with the given TTL and waits for the answer.
+// flow selects the flow identifier in Paris mode.
+// The returned int counts the ICMP messages received meanwhile that did not belong to the probe.
+func (t *Tracer) probe(ctx context.Context, tc *traceConn, ttl, index, flow int) (Probe, int, error) {
+	var probe Probe
+	var noise int
+	var err error
+	switch tc.opts.Method {
+	case TraceUDP:
+		probe, noise, err = t.probeUDP(ctx, tc, ttl, index, flow)
+	case TraceTCP:
+		probe, noise, err = t.probeTCP(ctx, tc, ttl)
+	default:
+		probe, noise, err = t.probeICMP(ctx, tc, ttl, flow)
+	}
+	probe.Flow = flow
+	return probe, noise, err
+}
+
+// probeICMP sends one echo request with the given TTL and waits for the answer.
+// Only an echo reply carrying the probe's ID and sequence, or an ICMP error quoting them, is accepted.
+// In Paris mode the payload is adjusted so that the checksum, which load balancers hash, is that of the flow.
+func (t *Tracer) probeICMP(ctx context.Context, tc *traceConn, ttl, flow int) (Probe, int, error) {
+	var echoType icmp.Type = ipv4.ICMPTypeEcho
+	if tc.proto == protocolICMPv6 {
+		echoType = ipv6.ICMPTypeEchoRequest
+	}
+
+	seq := int(t.seq.Add(1)) & 0xffff
+	data := tc.opts.payload()
+	if tc.opts.Paris {
+		data = append(data, 0, 0) // Checksum filler
+	}
+	message := icmp.Message{
+		Type: echoType, Code: 0,
+		Body: &icmp.Echo{
+			ID:   t.id,
+			Seq:  seq,
+			Data: data,
+		},
+	}
+	b, err := message.Marshal(nil)
+	if err != nil {
+		return Probe{}, 0, fmt.Errorf("unable to marshal ICMP message: %w", err)
+	}
+	if tc.opts.Paris {
+		parisEcho(b, tc.src, tc.dst.IP, uint16(t.id+flow))
+	}
+
+	w := tc.wait(time.Now(), func(reply *icmp.Message) bool {
+		id, replySeq, ok := echoIdentity(tc.proto, reply)
+		return ok && id == t.id && replySeq == seq
+	})
+	tc.sendMu.Lock()
+	if tc.proto == protocolICMPv6 {
+		err = tc.icmp.p6.SetHopLimit(ttl)
+	} else {
+		err = tc.icmp.p4.SetTTL(ttl)
+	}
+	if err == nil {
+		if _, err = tc.icmp.WriteTo(b, tc.dst); err != nil {
+			err = fmt.Errorf("unable to send ICMP message: %w", err)
+		}
+	} else {
+		err = fmt.Errorf("unable to set TTL: %w", err)
+	}
+	tc.sendMu.Unlock()
+	if err != nil {
+		tc.cancel(w)
+		return Probe{}, 0, err
+	}
+	return tc.await(ctx, w)
+}
+
+// probeUDP sends one UDP datagram with the given TTL to the index-th port after the first destination port.
+// The answer is the ICMP error quoting the datagram's source and destination ports.
+// In Paris mode the destination port is that of the flow, and probes are told apart by their UDP checksum instead.
+func (t *Tracer) probeUDP(ctx context.Context, tc *traceConn, ttl, index, flow int) (Probe, int, error) {
+	port := tc.port + index%(0x10000-tc.port)
+	payload := tc.opts.payload()
+	checksum, partial, length := -1, -1, -1 // Any checksum matches
+	if tc.opts.Paris {
+		port = tc.port + flow%(0x10000-tc.port)
+		checksum = index%0xfffe + 1 // Never 0, which means no checksum
+		// With checksum offload, errors generated by this host or by virtual routers sharing its offload
+		// quote the unfinished checksum, which is the same for every probe of a flow of a given length.
+		// The length then tells the probe apart.
+		payload = append(payload, make([]byte, index%parisUDPLengths)...)
+		payload = parisUDPPayload(payload, tc.src, tc.dst.IP, tc.udpPort, port, uint16(checksum))
+		length = 8 + len(payload)
+		partial = int(foldSum(onesSum(0, pseudoHeader(tc.src, tc.dst.IP, protocolUDP, length))))
+	}
+
+	w := tc.wait(time.Now(), func(reply *icmp.Message) bool {
+		transport, header, ok := quotedTransport(tc.proto, reply)
+		if !ok || transport != protocolUDP {
+			return false
+		}
+		srcPort, dstPort := headerPorts(header)
+		replyLength := int(binary.BigEndian.Uint16(header[4:]))
+		replyChecksum := int(binary.BigEndian.Uint16(header[6:]))
+		return srcPort == tc.udpPort && dstPort == port &&
+			(checksum < 0 || replyChecksum == checksum || replyChecksum == partial && replyLength == length)
+	})
+	var err error
+	tc.sendMu.Lock()
+	if tc.proto == protocolICMPv6 {
+		err = ipv6.NewPacketConn(tc.udp).SetHopLimit(ttl)
+	} else {
+		err = ipv4.NewPacketConn(tc.udp).SetTTL(ttl)
+	}
+	if err == nil {
+		dst := &net.UDPAddr{IP: tc.dst.IP, Port: port, Zone: tc.dst.Zone}
+		if _, err = tc.udp.WriteTo(payload, dst); err != nil {
+			err = fmt.Errorf("unable to send UDP datagram: %w", err)
+		}
+	} else {
+		err = fmt.Errorf("unable to set TTL: %w", err)
+	}
+	tc.sendMu.Unlock()
+	if err != nil {
+		tc.cancel(w)
+		return Probe{}, 0, err
+	}
+
+	probe, noise, err := tc.await(ctx, w)
+	if probe.Reply == ProbeUnreachable && probe.Err.Kind == PingPortUnreachable && probe.Addr.Equal(tc.dst.IP) {
+		// Nothing listens on the high port at the destination, which is how it answers
+		probe.Reply, probe.Err = ProbePortUnreachable, nil
+	}
+	return probe, noise, err
+}
+
+// probeTCP connects to the destination port with the given TTL from a source port unique to the probe.
+// The destination answers with SYN-ACK or RST, which completes or refuses the connection;
+// routers on the path answer with an ICMP error quoting the SYN's ports.
+func (t *Tracer) probeTCP(ctx context.Context, tc *traceConn, ttl int) (Probe, int, error) {
+	network := "tcp4"
+	if tc.proto == protocolICMPv6 {
+		network = "tcp6"
+	}
+	srcPort := tcpSourcePortBase + (t.id+int(t.seq.Add(1)))%tcpSourcePortCount
+	dialer := net.Dialer{
+		LocalAddr: &net.TCPAddr{Port: srcPort},
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = setTTL(fd, tc.proto == protocolICMPv6, ttl)
+				if sockErr == nil && tc.opts.DontFragment {
+					sockErr = setDontFragment(fd, tc.proto == protocolICMPv6)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+
+	w := tc.wait(time.Now(), func(reply *icmp.Message) bool {
+		transport, header, ok := quotedTransport(tc.proto, reply)
+		if !ok || transport != protocolTCP {
+			return false
+		}
+		replySrcPort, dstPort := headerPorts(header)
+		return replySrcPort == srcPort && dstPort == tc.port
+	})
+	dialCtx, cancel := context.WithTimeout(ctx, tc.opts.Timeout)
+	defer cancel()
+
+	type dialResult struct {
+		rtt time.Duration
+		err error
+	}
+	done := make(chan dialResult, 1)
+	go func() {
+		conn, err := dialer.DialContext(dialCtx, network, net.JoinHostPort(tc.dst.String(), strconv.Itoa(tc.port)))
+		rtt := time.Since(w.startTime)
+		if err == nil {
+			// Reset the connection rather than leave it in TIME_WAIT on a port later probes may reuse
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		}
+		done <- dialResult{rtt: rtt, err: err}
+	}()
+
+	// A router's ICMP error also makes the connection attempt fail, so wait for either
+	select {
+	case probe := <-w.reply:
+		return probe, tc.cancel(w), nil
+	case result := <-done:
+		if result.err == nil {
+			return Probe{Reply: ProbeSynAck, Addr: tc.dst.IP, RTT: result.rtt}, tc.cancel(w), nil
+		}
+		if isConnectionRefused(result.err) {
+			return Probe{Reply: ProbeReset, Addr: tc.dst.IP, RTT: result.rtt}, tc.cancel(w), nil
+		}
+		// The ICMP error that caused the failure may still be on its way through the read loop
+		return tc.await(ctx, w)
+	}
+}
+
+// quotedTransport decodes the IP header quoted in an ICMP error message and returns the transport
+// protocol and the first 8 bytes of the original UDP or TCP header.
+func quotedTransport(proto int, message *icmp.Message) (int, []byte, bool) {
+	data, ok := quotedDatagram(message)
+	if !ok {
+		return 0, nil, false
+	}
+	switch proto {
+	case protocolICMPv4:
+		if len(data) < ipv4.HeaderLen || data[0]>>4 != 4 {
+			return 0, nil, false
+		}
+		headerLen := int(data[0]&0x0f) << 2
+		if len(data) < headerLen+8 {
+			return 0, nil, false
+		}
+		return int(data[9]), data[headerLen : headerLen+8], true
+	case protocolICMPv6:
+		if len(data) < ipv6.HeaderLen+8 || data[0]>>4 != 6 {
+			return 0, nil, false
+		}
+		return int(data[6]), data[ipv6.HeaderLen : ipv6.HeaderLen+8], true
+	}
+	return 0, nil, false
+}
+
+// headerPorts returns the source and destination ports of a UDP or TCP header.
+func headerPorts(header []byte) (int, int) {
+	return int(binary.BigEndian.Uint16(header[0:])), int(binary.BigEndian.Uint16(header[2:]))
+}
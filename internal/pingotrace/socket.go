@@ -0,0 +1,114 @@
+package pingotrace
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// icmpSocket is a raw ICMP or ICMPv6 socket with the IP-level options applied to every packet it sends.
+type icmpSocket struct {
+	proto int
+	conn  net.PacketConn
+	p4    *ipv4.PacketConn // Set for ICMP sockets
+	p6    *ipv6.PacketConn // Set for ICMPv6 sockets
+}
+
+// socketKey identifies the set of IP-level options a socket was opened with.
+type socketKey struct {
+	proto        int
+	ttl          int
+	tos          int
+	dontFragment bool
+}
+
+// key returns the socketKey for sending echo requests of the given protocol with opts.
+func (o PingOptions) key(proto int) socketKey {
+	return socketKey{proto: proto, ttl: o.TTL, tos: o.TOS, dontFragment: o.DontFragment}
+}
+
+// listenICMP opens a raw socket for proto and applies the TTL, TOS and DF settings in key.
+// The TTL (IPv4) or hop limit (IPv6) of received packets is requested as a control message.
+func listenICMP(key socketKey) (*icmpSocket, error) {
+	network, address := "ip4:icmp", "0.0.0.0"
+	if key.proto == protocolICMPv6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+
+	listenConfig := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			if !key.dontFragment {
+				return nil
+			}
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = setDontFragment(fd, key.proto == protocolICMPv6)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	conn, err := listenConfig.ListenPacket(context.Background(), network, address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open ICMP connection: %w", err)
+	}
+
+	socket := &icmpSocket{proto: key.proto, conn: conn}
+	if key.proto == protocolICMPv6 {
+		socket.p6 = ipv6.NewPacketConn(conn)
+		// Not all platforms support control messages; the hop limit is then reported as 0
+		socket.p6.SetControlMessage(ipv6.FlagHopLimit, true)
+		if key.ttl > 0 {
+			err = socket.p6.SetHopLimit(key.ttl)
+		}
+		if err == nil && key.tos > 0 {
+			err = socket.p6.SetTrafficClass(key.tos)
+		}
+	} else {
+		socket.p4 = ipv4.NewPacketConn(conn)
+		socket.p4.SetControlMessage(ipv4.FlagTTL, true)
+		if key.ttl > 0 {
+			err = socket.p4.SetTTL(key.ttl)
+		}
+		if err == nil && key.tos > 0 {
+			err = socket.p4.SetTOS(key.tos)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to set socket options: %w", err)
+	}
+	return socket, nil
+}
+
+// ReadFrom reads one ICMP message together with the TTL or hop limit it arrived with.
+func (s *icmpSocket) ReadFrom(buf []byte) (int, int, net.Addr, error) {
+	if s.p6 != nil {
+		n, cm, peer, err := s.p6.ReadFrom(buf)
+		if err != nil || cm == nil {
+			return n, 0, peer, err
+		}
+		return n, cm.HopLimit, peer, nil
+	}
+	n, cm, peer, err := s.p4.ReadFrom(buf)
+	if err != nil || cm == nil {
+		return n, 0, peer, err
+	}
+	return n, cm.TTL, peer, nil
+}
+
+// WriteTo sends the marshalled ICMP message b to dst.
+func (s *icmpSocket) WriteTo(b []byte, dst net.Addr) (int, error) {
+	return s.conn.WriteTo(b, dst)
+}
+
+// Close closes the socket.
+func (s *icmpSocket) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,50 @@
+package pingotrace
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Socket options from netinet/in.h and netinet6/in6.h, not exported by the syscall package
+const (
+	sysIP_DONTFRAG   = 28
+	sysIPV6_DONTFRAG = 62
+)
+
+// setDontFragment sets the DF bit on every packet sent from the socket,
+// and disables local fragmentation for IPv6.
+func setDontFragment(fd uintptr, ipv6 bool) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, sysIPV6_DONTFRAG, 1)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, sysIP_DONTFRAG, 1)
+}
+
+// setTTL sets the TTL (IPv4) or unicast hop limit (IPv6) of packets sent from the socket.
+func setTTL(fd uintptr, ipv6 bool, ttl int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// isConnectionRefused reports whether a connect error was caused by a TCP reset.
+func isConnectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isMessageTooLong reports whether a send error means the packet exceeds the MTU and may not be fragmented.
+func isMessageTooLong(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
+
+// unreachableKind returns the kind of a connect error caused by an ICMP unreachable, or 0 for other errors.
+func unreachableKind(err error) PingErrorKind {
+	switch {
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return PingHostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH):
+		return PingNetUnreachable
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+//go:build !linux && !windows && !darwin
+
+package pingotrace
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setDontFragment is not implemented on this platform.
+func setDontFragment(fd uintptr, ipv6 bool) error {
+	return errors.New("don't fragment is not supported on this platform")
+}
+
+// setTTL is not implemented on this platform.
+func setTTL(fd uintptr, ipv6 bool, ttl int) error {
+	return errors.New("setting the TTL of TCP probes is not supported on this platform")
+}
+
+// isConnectionRefused reports whether a connect error was caused by a TCP reset.
+func isConnectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isMessageTooLong reports whether a send error means the packet exceeds the MTU and may not be fragmented.
+func isMessageTooLong(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
+
+// unreachableKind returns the kind of a connect error caused by an ICMP unreachable, or 0 for other errors.
+func unreachableKind(err error) PingErrorKind {
+	switch {
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return PingHostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH):
+		return PingNetUnreachable
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package pingotrace
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Socket options from ws2ipdef.h, not exported by the syscall package
+const (
+	sysIP_DONTFRAGMENT = 14
+	sysIPV6_DONTFRAG   = 14
+)
+
+// Winsock error codes from winerror.h; the syscall package's POSIX errors are not what Winsock returns
+const (
+	sysWSAEMSGSIZE     syscall.Errno = 10040
+	sysWSAENETUNREACH  syscall.Errno = 10051
+	sysWSAECONNREFUSED syscall.Errno = 10061
+	sysWSAEHOSTUNREACH syscall.Errno = 10065
+)
+
+// setDontFragment sets the DF bit on every packet sent from the socket,
+// and disables local fragmentation for IPv6.
+func setDontFragment(fd uintptr, ipv6 bool) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, sysIPV6_DONTFRAG, 1)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, sysIP_DONTFRAGMENT, 1)
+}
+
+// setTTL sets the TTL (IPv4) or unicast hop limit (IPv6) of packets sent from the socket.
+func setTTL(fd uintptr, ipv6 bool, ttl int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// isConnectionRefused reports whether a connect error was caused by a TCP reset.
+func isConnectionRefused(err error) bool {
+	return errors.Is(err, sysWSAECONNREFUSED)
+}
+
+// isMessageTooLong reports whether a send error means the packet exceeds the MTU and may not be fragmented.
+func isMessageTooLong(err error) bool {
+	return errors.Is(err, sysWSAEMSGSIZE)
+}
+
+// unreachableKind returns the kind of a connect error caused by an ICMP unreachable, or 0 for other errors.
+func unreachableKind(err error) PingErrorKind {
+	switch {
+	case errors.Is(err, sysWSAEHOSTUNREACH):
+		return PingHostUnreachable
+	case errors.Is(err, sysWSAENETUNREACH):
+		return PingNetUnreachable
+	}
+	return 0
+}
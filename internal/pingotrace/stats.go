@@ -0,0 +1,115 @@
+package pingotrace
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// PingStats accumulates the results of a ping session against one target.
+// The zero value is ready to use and it is safe for concurrent use.
+type PingStats struct {
+	mu sync.Mutex
+
+	sent       int
+	received   int
+	min        time.Duration
+	max        time.Duration
+	sum        float64 // Sum of RTTs in nanoseconds
+	sumSquares float64 // Sum of squared RTTs, for mdev
+	jitter     float64 // RFC 3550 interarrival jitter in nanoseconds
+	lastRTT    time.Duration
+	lossStreak int
+	maxStreak  int
+}
+
+// PingSummary is a point-in-time copy of PingStats.
+type PingSummary struct {
+	Sent          int
+	Received      int
+	Lost          int
+	LossPercent   float64
+	Min           time.Duration
+	Avg           time.Duration
+	Max           time.Duration
+	Mdev          time.Duration // Standard deviation of the RTT, as reported by iputils ping
+	Jitter        time.Duration // RFC 3550 interarrival jitter
+	Last          time.Duration // RTT of the last successful reply
+	MaxLossStreak int           // Longest run of consecutive lost requests
+}
+
+// Add records the result of one echo request.
+func (s *PingStats) Add(result PingResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent++
+	if !result.Success() {
+		s.lossStreak++
+		if s.lossStreak > s.maxStreak {
+			s.maxStreak = s.lossStreak
+		}
+		return
+	}
+	s.lossStreak = 0
+
+	rtt := result.RTT
+	if s.received == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+
+	// RFC 3550 section 6.4.1: J += (|D| - J) / 16, where D is the change in transit time
+	if s.received > 0 {
+		d := math.Abs(float64(rtt - s.lastRTT))
+		s.jitter += (d - s.jitter) / 16
+	}
+
+	s.received++
+	s.sum += float64(rtt)
+	s.sumSquares += float64(rtt) * float64(rtt)
+	s.lastRTT = rtt
+}
+
+// Summary returns the statistics recorded so far.
+func (s *PingStats) Summary() PingSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := PingSummary{
+		Sent:          s.sent,
+		Received:      s.received,
+		Lost:          s.sent - s.received,
+		Min:           s.min,
+		Max:           s.max,
+		Jitter:        time.Duration(s.jitter),
+		Last:          s.lastRTT,
+		MaxLossStreak: s.maxStreak,
+	}
+	if s.sent > 0 {
+		summary.LossPercent = float64(summary.Lost) / float64(s.sent) * 100
+	}
+	if s.received > 0 {
+		avg := s.sum / float64(s.received)
+		variance := s.sumSquares/float64(s.received) - avg*avg
+		summary.Avg = time.Duration(avg)
+		summary.Mdev = time.Duration(math.Sqrt(math.Max(variance, 0)))
+	}
+	return summary
+}
+
+// String formats the summary on one line for display under a ping table.
+func (s PingSummary) String() string {
+	return fmt.Sprintf("Sent = %d, Received = %d, Lost = %d (%.1f%% loss), Longest loss streak = %d    "+
+		"Min/Avg/Max/Mdev = %s/%s/%s/%s, Jitter = %s",
+		s.Sent, s.Received, s.Lost, s.LossPercent, s.MaxLossStreak,
+		formatMs(s.Min), formatMs(s.Avg), formatMs(s.Max), formatMs(s.Mdev), formatMs(s.Jitter))
+}
+
+// formatMs formats a duration in milliseconds with two decimals.
+func formatMs(d time.Duration) string {
+	return fmt.Sprintf("%.2f ms", float64(d)/float64(time.Millisecond))
+}
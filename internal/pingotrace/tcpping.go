@@ -0,0 +1,60 @@
+package pingotrace
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// TCPPing opens a TCP connection to port on ipAddr and closes it as soon as the handshake completes,
+// for hosts that drop ICMP echo. The RTT is the time the handshake took.
+// A reset is reported as PingRefused, no answer within timeout as PingFiltered,
+// and an ICMP unreachable from a router as the matching unreachable kind.
+func TCPPing(ctx context.Context, ipAddr *net.IPAddr, port int, timeout time.Duration) PingResult {
+	result := PingResult{Addr: ipAddr, Port: port}
+	if timeout <= 0 {
+		result.Err = &PingError{Kind: PingSocketError, Err: errors.New("timeout must be positive")}
+		return result
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	address := net.JoinHostPort(ipAddr.String(), strconv.Itoa(port))
+	startTime := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	rtt := time.Since(startTime)
+	if err == nil {
+		result.From = conn.RemoteAddr()
+		result.RTT = rtt
+		conn.Close()
+		return result
+	}
+
+	if ctx.Err() != nil {
+		result.Err = &PingError{Kind: PingTimeout, Err: ctx.Err()}
+		return result
+	}
+	pingErr := connectError(err)
+	if pingErr.Kind == PingRefused {
+		result.From = &net.TCPAddr{IP: ipAddr.IP, Port: port, Zone: ipAddr.Zone}
+	}
+	result.Err = pingErr
+	return result
+}
+
+// connectError classifies the error of a failed TCP connection attempt: a reset as PingRefused,
+// no answer in time as PingFiltered, and an ICMP unreachable from a router as the matching unreachable kind.
+func connectError(err error) *PingError {
+	var netErr net.Error
+	switch {
+	case isConnectionRefused(err):
+		return &PingError{Kind: PingRefused}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return &PingError{Kind: PingFiltered}
+	case unreachableKind(err) != 0:
+		// The router that sent the ICMP error is not known to the socket
+		return &PingError{Kind: unreachableKind(err), Err: err}
+	}
+	return &PingError{Kind: PingSocketError, Err: err}
+}
@@ -0,0 +1,141 @@
+package pingotrace
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of TLS inspection
+const (
+	DefaultTLSPort    = 443             // Port inspected for targets without one
+	DefaultTLSTimeout = 5 * time.Second // Time allowed for the connection and the handshake
+)
+
+// TLSCertificate describes one certificate of the chain a server presented.
+type TLSCertificate struct {
+	Subject   string
+	SANs      []string // DNS names, IP addresses, e-mail addresses and URIs the certificate is valid for
+	Issuer    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	DaysLeft  int // Whole days until NotAfter, negative once expired
+}
+
+// TLSResult is the outcome of inspecting the TLS service of one target.
+type TLSResult struct {
+	Target      string // Target as given
+	Addr        string // Address and port connected to
+	ServerName  string // Name sent as SNI and verified, the host of the target
+	Version     string // Negotiated protocol, e.g. "TLS 1.3"
+	CipherSuite string // Negotiated cipher suite
+	OCSPStapled bool   // The server stapled an OCSP response to the handshake
+
+	Chain       []TLSCertificate // Certificates as sent by the server, leaf first
+	HostnameErr error            // Why the leaf is not valid for ServerName, nil if it is
+	VerifyErr   error            // Why the chain is not trusted by this system for ServerName, nil if it is
+	Err         error            // Why no handshake took place; the other fields are then empty
+}
+
+// InspectTLS connects to target, a host with an optional ":port" (default 443) or an https URL,
+// completes a TLS handshake without verifying the certificate, and reports the negotiated parameters,
+// the certificate chain, and the outcome of hostname and chain verification done afterwards.
+func InspectTLS(ctx context.Context, target string, timeout time.Duration) TLSResult {
+	result := TLSResult{Target: target}
+	host, port := SplitPort(target)
+	if port == 0 {
+		port = DefaultTLSPort
+		if u := ParseURL(target); u != nil && u.Scheme == "http" {
+			port = 80
+		}
+	}
+	name, _ := SplitFamily(host)
+	result.ServerName = strings.Trim(name, "[]")
+
+	ipAddr, err := ResolveTarget(host)
+	if err != nil {
+		result.Err = fmt.Errorf("unable to resolve %s: %w", result.ServerName, err)
+		return result
+	}
+	result.Addr = net.JoinHostPort(ipAddr.String(), strconv.Itoa(port))
+
+	dialer := tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: timeout},
+		Config: &tls.Config{
+			ServerName: result.ServerName,
+			// Invalid certificates are what this tool is for; they are verified below instead
+			InsecureSkipVerify: true,
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", result.Addr)
+	if err != nil {
+		if pingErr := connectError(err); pingErr.Kind != PingSocketError {
+			// Refused, filtered or unreachable read better than the dial error
+			err = pingErr
+		}
+		result.Err = fmt.Errorf("unable to complete TLS handshake with %s: %w", result.Addr, err)
+		return result
+	}
+	state := conn.(*tls.Conn).ConnectionState()
+	conn.Close()
+
+	result.Version = tls.VersionName(state.Version)
+	result.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	result.OCSPStapled = len(state.OCSPResponse) > 0
+	now := time.Now()
+	for _, cert := range state.PeerCertificates {
+		result.Chain = append(result.Chain, TLSCertificate{
+			Subject:   cert.Subject.String(),
+			SANs:      certificateSANs(cert),
+			Issuer:    cert.Issuer.String(),
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			DaysLeft:  int(math.Floor(cert.NotAfter.Sub(now).Hours() / 24)),
+		})
+	}
+	if len(state.PeerCertificates) == 0 {
+		result.HostnameErr = errors.New("no certificate presented")
+		result.VerifyErr = result.HostnameErr
+		return result
+	}
+
+	leaf := state.PeerCertificates[0]
+	result.HostnameErr = leaf.VerifyHostname(result.ServerName)
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, result.VerifyErr = leaf.Verify(x509.VerifyOptions{DNSName: result.ServerName, Intermediates: intermediates})
+	return result
+}
+
+// InspectTLSAll inspects up to DefaultParallel targets at once and returns the results in the order of targets.
+func InspectTLSAll(ctx context.Context, targets []string, timeout time.Duration) []TLSResult {
+	results := make([]TLSResult, len(targets))
+	ForEachParallel(len(targets), DefaultParallel, func(i int) {
+		results[i] = InspectTLS(ctx, targets[i], timeout)
+	})
+	return results
+}
+
+// certificateSANs lists the subject alternative names of cert.
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
@@ -2,161 +2,447 @@ package pingotrace
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+)
+
+// TraceMethod is the kind of packet sent as traceroute probe.
+type TraceMethod int
+
+const (
+	TraceICMP TraceMethod = iota // ICMP echo requests, answered by an echo reply at the destination
+	TraceUDP                     // UDP datagrams to incrementing high ports, answered by port unreachable
+	TraceTCP                     // TCP SYN to one port, answered by SYN-ACK or RST
+)
 
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
+// Default destination ports of UDP and TCP probes
+const (
+	DefaultUDPPort = 33434
+	DefaultTCPPort = 80
 )
 
-// Windows ICMP
-func Trace(destIP string, maxHops int, timeout time.Duration, ctx context.Context, traceOutputChan chan []string) {
-	// Resolve the destination IP address
-	ipAddr, err := net.ResolveIPAddr("ip4", destIP)
+// Transport protocol numbers found in quoted datagrams
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+// Source ports of TCP probes, taken from the dynamic range so that each probe has its own
+const (
+	tcpSourcePortBase  = 49152
+	tcpSourcePortCount = 16384
+)
+
+// String returns the method name as used by ParseTraceMethod.
+func (m TraceMethod) String() string {
+	switch m {
+	case TraceUDP:
+		return "UDP"
+	case TraceTCP:
+		return "TCP"
+	default:
+		return "ICMP"
+	}
+}
+
+// ParseTraceMethod parses "ICMP", "UDP", "TCP" with an optional ":port", e.g. "TCP:443".
+// A zero port means the method's default port.
+func ParseTraceMethod(text string) (TraceMethod, int, error) {
+	name, portText, hasPort := strings.Cut(strings.TrimSpace(text), ":")
+	var method TraceMethod
+	switch strings.ToUpper(name) {
+	case "ICMP":
+		if hasPort {
+			return 0, 0, errors.New("ICMP probes have no port")
+		}
+		return TraceICMP, 0, nil
+	case "UDP":
+		method = TraceUDP
+	case "TCP":
+		method = TraceTCP
+	default:
+		return 0, 0, fmt.Errorf("unknown trace method %q, use ICMP, UDP or TCP", name)
+	}
+	if !hasPort {
+		return method, 0, nil
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 0xffff {
+		return 0, 0, fmt.Errorf("%q is not a valid port", portText)
+	}
+	return method, port, nil
+}
+
+// TraceOptions controls a traceroute run.
+type TraceOptions struct {
+	Method       TraceMethod   // Kind of probe sent
+	Port         int           // Destination port of TCP probes, first destination port of UDP probes; 0 for the default
+	Paris        bool          // Keep the flow identifier of ICMP and UDP probes constant so load balancers keep them on one path
+	MultiPath    bool          // Implies Paris; probe each hop over new flows until every load-balanced next hop is found
+	MaxFlows     int           // Most flows probed per hop in MultiPath mode
+	Parallel     int           // TTLs probed at once; 0 or 1 probes one TTL after the other
+	MaxHops      int           // Highest TTL probed
+	ProbesPerHop int           // Probes sent with each TTL
+	Timeout      time.Duration // Wait for the reply to each probe
+	FirstTTL     int           // TTL of the first hop probed
+	Wait         time.Duration // Pause between two probes
+	ResolvePTR   bool          // Look up the host name of every responder
+	Size         int           // Data bytes of ICMP and UDP probes after their header, 0 for the default 10; Paris mode adds 2
+	DontFragment bool          // Set DF on IPv4 probes and disable local fragmentation on IPv6
+}
+
+// DefaultTraceOptions returns the options used by TRACE, PINGOTRACE and ∞ TRACE.
+func DefaultTraceOptions() TraceOptions {
+	return TraceOptions{
+		MaxHops:      30,
+		ProbesPerHop: 3,
+		Timeout:      1 * time.Second,
+		FirstTTL:     1,
+		MaxFlows:     64,
+		Parallel:     16,
+		ResolvePTR:   true,
+	}
+}
+
+// SetMethod sets Method and Port from text as accepted by ParseTraceMethod.
+// A leading "PARIS" keeps the flow of every probe constant, "MDA" also enumerates all load-balanced paths,
+// e.g. "PARIS UDP" or "MDA ICMP".
+func (o *TraceOptions) SetMethod(text string) error {
+	o.Paris, o.MultiPath = false, false
+	mode, rest, found := strings.Cut(strings.TrimSpace(text), " ")
+	switch strings.ToUpper(mode) {
+	case "PARIS":
+		o.Paris = true
+	case "MDA":
+		o.Paris, o.MultiPath = true, true
+	default:
+		found = false
+	}
+	if !found {
+		rest = text
+	}
+
+	method, port, err := ParseTraceMethod(rest)
 	if err != nil {
-		traceOutputChan <- []string{fmt.Sprintf("Unable to resolve destination IP address: %s", err)}
-		return
+		return err
+	}
+	if o.Paris && method == TraceTCP {
+		return errors.New("Paris and MDA modes need ICMP or UDP probes")
+	}
+	o.Method, o.Port = method, port
+	return nil
+}
+
+// payload returns the data of ICMP and UDP probes, "PinGoTrace" repeated to Size bytes.
+func (o TraceOptions) payload() []byte {
+	if o.Size == 0 {
+		return []byte("PinGoTrace")
+	}
+	return PingOptions{Size: o.Size, Pattern: []byte("PinGoTrace")}.payload()
+}
+
+// ProbeReply classifies the answer to a single traceroute probe.
+type ProbeReply int
+
+const (
+	ProbeTimeout         ProbeReply = iota // No answer within the timeout
+	ProbeTimeExceeded                      // A router on the path answered with Time Exceeded
+	ProbeEchoReply                         // The destination answered an echo request
+	ProbeUnreachable                       // A router or the destination answered with Destination Unreachable
+	ProbePortUnreachable                   // The destination answered a UDP probe with Port Unreachable
+	ProbeSynAck                            // The destination accepted a TCP probe, the port is open
+	ProbeReset                             // The destination reset a TCP probe, the port is closed
+)
+
+// Probe is the outcome of one traceroute probe.
+type Probe struct {
+	Reply ProbeReply
+	Addr  net.IP        // Responder, nil on timeout
+	RTT   time.Duration // Round-trip time, zero on timeout
+	Err   *PingError    // Reason reported with ProbeUnreachable
+	Flow  int           // Flow the probe was sent on in Paris mode
+
+	MPLS       []MPLSLabel     // Label stack the responder quoted, outermost label first (RFC 4950)
+	Interfaces []InterfaceInfo // Interface information the responder included (RFC 5837)
+}
+
+// Destination reports whether the probe was answered by the destination itself.
+func (p Probe) Destination() bool {
+	switch p.Reply {
+	case ProbeEchoReply, ProbePortUnreachable, ProbeSynAck, ProbeReset:
+		return true
+	}
+	return false
+}
+
+// Label returns the probe as shown in the trace output: "RTT: 12ms", "*" or an unreachable mark such as "!H".
+func (p Probe) Label() string {
+	switch p.Reply {
+	case ProbeTimeout:
+		return "*"
+	case ProbeUnreachable:
+		return unreachableMark(p.Err)
+	default:
+		return fmt.Sprintf("RTT: %v", p.RTT.Round(time.Millisecond))
+	}
+}
+
+// Hop is the result of probing one TTL.
+// With load balancing in the path, probes of the same TTL can be answered by different routers;
+// each of them is listed in Responders.
+type Hop struct {
+	TTL        int
+	Addr       net.IP      // First responder of the hop, nil if every probe timed out
+	Name       string      // Host name of Addr when TraceOptions.ResolvePTR is set
+	Probes     []Probe     // One entry per probe, in sending order
+	Responders []Responder // Distinct responders in order of first answer
+	Reached    bool        // The destination itself answered
+	Noise      int         // ICMP messages received while probing this hop that matched none of its probes
+}
+
+// Responder is one router or host that answered probes of a hop.
+type Responder struct {
+	Addr   net.IP
+	Name   string // Host name of Addr when TraceOptions.ResolvePTR is set
+	Probes []int  // Indexes into Hop.Probes of the probes it answered
+}
+
+// Host returns the responder as "name [ip]" or "ip".
+func (r Responder) Host() string {
+	if r.Name != "" {
+		return fmt.Sprintf("%s [%s]", r.Name, r.Addr)
+	}
+	return r.Addr.String()
+}
+
+// Host returns the first responder as "name [ip]", "ip", or "Request timed out" for silent hops.
+func (h Hop) Host() string {
+	if len(h.Responders) == 0 {
+		return "Request timed out"
 	}
+	return h.Responders[0].Host()
+}
+
+// addResponders groups the answered probes of the hop by responder and sets Addr to the first one.
+func (h *Hop) addResponders() {
+	h.Responders = nil
+	for i, probe := range h.Probes {
+		if probe.Reply == ProbeTimeout {
+			continue
+		}
+		found := false
+		for r := range h.Responders {
+			if h.Responders[r].Addr.Equal(probe.Addr) {
+				h.Responders[r].Probes = append(h.Responders[r].Probes, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			h.Responders = append(h.Responders, Responder{Addr: probe.Addr, Probes: []int{i}})
+		}
+	}
+	if len(h.Responders) > 0 {
+		h.Addr = h.Responders[0].Addr
+	}
+}
+
+// Final reports whether the trace ends at this hop, because the destination answered
+// or a router reported it as unreachable.
+func (h Hop) Final() bool {
+	if h.Reached {
+		return true
+	}
+	for _, probe := range h.Probes {
+		if probe.Reply == ProbeUnreachable {
+			return true
+		}
+	}
+	return false
+}
+
+// Tracer runs traceroutes with ICMP, UDP or TCP probes, IPv4 or IPv6 depending on the destination.
+type Tracer struct {
+	Options TraceOptions
+	id      int          // Echo ID of every probe sent by this Tracer
+	seq     atomic.Int32 // Echo sequence counter, also used to pick TCP source ports
+}
 
-	// Create an ICMP packet connection
-	pktConn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+// NewTracer returns a Tracer using opts.
+func NewTracer(opts TraceOptions) *Tracer {
+	return &Tracer{Options: opts, id: rand.Intn(0xffff) + 1}
+}
+
+// Run traces the path to target and sends one Hop per TTL to hops, in TTL order, closing it when done.
+// Up to Options.Parallel TTLs are probed at once. It returns when the destination answered,
+// MaxHops was reached or ctx was cancelled. Errors that prevent the trace from starting or continuing are returned.
+func (t *Tracer) Run(ctx context.Context, target string, hops chan<- Hop) error {
+	defer close(hops)
+
+	tc, err := t.open(target)
 	if err != nil {
-		traceOutputChan <- []string{fmt.Sprintf("Unable to open ICMP connection: %s", err)}
-		return
+		return err
 	}
-	defer pktConn.Close()
+	defer tc.Close()
+	opts := tc.opts
 
-	// Flag to indicate if destination has been reached
-	destinationReached := false
+	// Probing of the TTLs still in flight stops once the trace is over
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// Loop through the hop counts up to maxHops
-	var hop int
-	for hop = 1; hop <= maxHops; hop++ {
-		if destinationReached {
-			break
+	type hopResult struct {
+		hop Hop
+		err error
+	}
+	results := make(map[int]chan hopResult)
+	launched := opts.FirstTTL
+	for ttl := opts.FirstTTL; ttl <= opts.MaxHops; ttl++ {
+		// Keep up to Parallel TTLs in flight, starting from the first hop not sent yet
+		for ; launched <= opts.MaxHops && launched < ttl+opts.Parallel; launched++ {
+			result := make(chan hopResult, 1)
+			results[launched] = result
+			wg.Add(1)
+			go func(ttl int) {
+				defer wg.Done()
+				hop, err := t.traceHop(ctx, tc, ttl)
+				result <- hopResult{hop: hop, err: err}
+			}(launched)
 		}
 
-		// Check for context cancellation
+		var result hopResult
 		select {
+		case result = <-results[ttl]:
 		case <-ctx.Done():
-			return
-		default:
-			// Set the TTL (Time To Live) for the current hop
-			pktConn.IPv4PacketConn().SetTTL(hop)
-
-			// Initialize slice to store round-trip times for each probe
-			responseTimes := make([]string, 3)
-			currentPeer := ""
-
-			// Send 3 probes per hop
-			for probe := 0; probe < 3; probe++ {
-				// Record the start time for this probe
-				startTime := time.Now()
-
-				// Set a read deadline for the connection
-				err = pktConn.SetReadDeadline(startTime.Add(timeout))
-				if err != nil {
-					traceOutputChan <- []string{fmt.Sprintf("Unable to set read deadline: %s", err)}
-					return
-				}
-
-				traceSeqNum := newTraceICMPSeq()
-				// Create an ICMP Echo Request message
-				var message icmp.Message
-				message = icmp.Message{
-					Type: ipv4.ICMPTypeEcho, Code: 0,
-					Body: &icmp.Echo{
-						ID:   newTraceICMPID(),
-						Seq:  traceSeqNum,
-						Data: []byte("PinGoTrace"),
-					},
-				}
-
-				// Marshal the ICMP message into bytes
-				b, err := message.Marshal(nil)
-				if err != nil {
-					traceOutputChan <- []string{fmt.Sprintf("Unable to marshal ICMP message: %s", err)}
-					return
-				}
-
-				// Send the ICMP message
-				_, err = pktConn.WriteTo(b, ipAddr)
-				if err != nil {
-					traceOutputChan <- []string{fmt.Sprintf("Unable to send ICMP message: %s", err)}
-					return
-				}
-
-				// Receive the ICMP response
-				buf := make([]byte, 1500)
-				n, peer, err := pktConn.ReadFrom(buf)
-				if err != nil {
-					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-						// Handle timeouts
-						responseTimes[probe] = "*"
-						continue
-					} else {
-						// Handle other errors
-						traceOutputChan <- []string{fmt.Sprintf("Unable to read ICMP message: %s", err)}
-						return
-					}
-				}
-
-				// Record the peer address
-				currentPeer = peer.(*net.IPAddr).String()
-
-				// Parse the received ICMP message
-				messagePtr, err := icmp.ParseMessage(1, buf[:n])
-				if err != nil {
-					traceOutputChan <- []string{fmt.Sprintf("Unable to parse ICMP message: %s", err)}
-					return
-				}
-				message = *messagePtr
-
-				// Calculate and record the round-trip time
-				rtt := time.Since(startTime).Round(time.Millisecond)
-				switch message.Type {
-				case ipv4.ICMPTypeTimeExceeded:
-					// Time exceeded, usually means still in transit
-					responseTimes[probe] = fmt.Sprintf("RTT: %v", rtt)
-				case ipv4.ICMPTypeEchoReply:
-					// Echo reply received, destination reached
-					responseTimes[probe] = fmt.Sprintf("RTT: %v", rtt)
-					destinationReached = true
-				default:
-					// Unexpected ICMP message received
-					responseTimes[probe] = "Unexpected ICMP message"
-				}
-			}
+			return nil
+		}
+		delete(results, ttl)
+		if result.err != nil {
+			return result.err
+		}
+		if ctx.Err() != nil {
+			// The hop was cut short
+			return nil
+		}
 
-			// If no reply is received, mark as timed out
-			if currentPeer == "" {
-				currentPeer = "Request timed out"
-			} else {
-				// Try to resolve the IP address to a hostname
-				dnsName, err := net.LookupAddr(currentPeer)
-				if err == nil && len(dnsName) > 0 {
-					currentPeer = fmt.Sprintf("%s [%s]", dnsName[0][:len(dnsName[0])-1], currentPeer)
-				}
+		select {
+		case hops <- result.hop:
+		case <-ctx.Done():
+			return nil
+		}
+		if result.hop.Final() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// traceHop sends the probes of one TTL and returns the hop with the names of its responders.
+func (t *Tracer) traceHop(ctx context.Context, tc *traceConn, ttl int) (Hop, error) {
+	opts := tc.opts
+	hop := Hop{TTL: ttl, Probes: make([]Probe, 0, opts.ProbesPerHop)}
+
+	for i := 0; t.moreProbes(tc, hop, i); i++ {
+		if i > 0 && opts.Wait > 0 {
+			select {
+			case <-time.After(opts.Wait):
+			case <-ctx.Done():
+				return hop, nil
 			}
+		}
+		if ctx.Err() != nil {
+			return hop, nil
+		}
 
-			// Send the output line to the channel for further processing or output
-			outputLine := append([]string{fmt.Sprintf("%2d", hop), currentPeer}, responseTimes...)
-			traceOutputChan <- outputLine
+		// Every probe goes on its own flow when enumerating paths, on the first flow otherwise
+		flow := 0
+		if opts.MultiPath {
+			flow = i
+		}
+		probe, noise, err := t.probe(ctx, tc, ttl, tc.nextIndex(), flow)
+		if err != nil {
+			return hop, err
+		}
+		hop.Noise += noise
+		hop.Probes = append(hop.Probes, probe)
+		if probe.Destination() {
+			hop.Reached = true
 		}
 	}
+	hop.addResponders()
+
+	// Try to resolve every responder's IP address to a hostname
+	if opts.ResolvePTR {
+		for r := range hop.Responders {
+			if name, ok := PTRLookup(ctx, hop.Responders[r].Addr.String()); ok {
+				hop.Responders[r].Name = name
+			}
+		}
+		if len(hop.Responders) > 0 {
+			hop.Name = hop.Responders[0].Name
+		}
+	}
+	return hop, nil
 }
 
-var (
-	localTraceRand  int32
-	seqTraceCounter int32
-)
+// moreProbes reports whether a hop that got sent probes so far needs another one.
+// In MultiPath mode probing goes on while a further responder could still show up,
+// following the stopping points of the Multipath Detection Algorithm.
+func (t *Tracer) moreProbes(tc *traceConn, hop Hop, sent int) bool {
+	if !tc.opts.MultiPath {
+		return sent < tc.opts.ProbesPerHop
+	}
+	var responders []net.IP
+	for _, probe := range hop.Probes {
+		if probe.Destination() {
+			// The destination has no next hops to enumerate
+			return sent < tc.opts.ProbesPerHop
+		}
+		if probe.Reply != ProbeTimeout && !containsIP(responders, probe.Addr) {
+			responders = append(responders, probe.Addr)
+		}
+	}
+	return sent < tc.opts.MaxFlows && sent < mdaStop(len(responders), tc.opts.ProbesPerHop)
+}
 
-func newTraceICMPSeq() int {
-	return int(atomic.AddInt32(&seqTraceCounter, 1))
+// containsIP checks if an IP address is present in a slice of IP addresses.
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, v := range ips {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
 }
 
-func newTraceICMPID() int {
-	return int(atomic.AddInt32(&seqTraceCounter, 1))
+// unreachableMark returns the traceroute-style mark for an unreachable reason.
+func unreachableMark(err *PingError) string {
+	if err == nil {
+		return "!"
+	}
+	switch err.Kind {
+	case PingNetUnreachable:
+		return "!N"
+	case PingHostUnreachable:
+		return "!H"
+	case PingProtocolUnreachable:
+		return "!P"
+	case PingPortUnreachable:
+		return "!U"
+	case PingFragmentationNeeded:
+		return "!F"
+	case PingSourceRouteFailed:
+		return "!S"
+	case PingAdminProhibited:
+		return "!X"
+	}
+	return fmt.Sprintf("!<%d>", err.Code)
 }
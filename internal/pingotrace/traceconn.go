@@ -0,0 +1,282 @@
+package pingotrace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// traceConn holds the options and sockets of one trace run.
+// A single read loop hands every ICMP message to the probe waiting for it,
+// so that probes for many TTLs can be outstanding at once.
+type traceConn struct {
+	opts    TraceOptions // Options of the run, with defaults applied
+	icmp    *icmpSocket  // Receives the answers to every probe and sends ICMP probes
+	proto   int          // ICMP protocol number of icmp
+	dst     *net.IPAddr
+	port    int            // Destination port of TCP probes, first destination port of UDP probes
+	udp     net.PacketConn // Sends UDP probes
+	udpPort int            // Local port of udp
+	src     net.IP         // Local address, needed for the checksums of Paris probes
+	index   atomic.Int32   // Probes sent so far, selects the destination port or checksum of UDP probes
+
+	sendMu sync.Mutex // Keeps the TTL set on a shared socket until the probe is sent
+
+	mu      sync.Mutex
+	waiters map[*traceWaiter]struct{} // Probes waiting for their answer
+	readErr error                     // Why the read loop stopped
+	done    chan struct{}             // Closed when the read loop stops
+}
+
+// traceWaiter is a probe waiting for its answer.
+type traceWaiter struct {
+	match     func(*icmp.Message) bool // Reports whether a message quotes or answers the probe
+	startTime time.Time
+	reply     chan Probe // Receives the answer, buffered
+	noise     int        // Messages received meanwhile that answered no probe; guarded by traceConn.mu
+}
+
+// open checks the options, resolves target and opens the sockets of a trace run.
+func (t *Tracer) open(target string) (*traceConn, error) {
+	opts := t.Options
+	if opts.MaxHops <= 0 || opts.ProbesPerHop <= 0 || opts.Timeout <= 0 {
+		return nil, errors.New("max hops, probes per hop and timeout must be positive")
+	}
+	if opts.FirstTTL <= 0 {
+		opts.FirstTTL = 1
+	}
+	if opts.Parallel <= 0 {
+		opts.Parallel = 1
+	}
+	if opts.MultiPath {
+		opts.Paris = true
+		if opts.MaxFlows <= 0 {
+			return nil, errors.New("max flows must be positive")
+		}
+	}
+	if opts.Paris && opts.Method == TraceTCP {
+		return nil, errors.New("Paris and MDA modes need ICMP or UDP probes")
+	}
+	if opts.Size < 0 || opts.Size > 65500 {
+		return nil, fmt.Errorf("probe size %d is out of range 0-65500", opts.Size)
+	}
+
+	// Resolve the destination IP address
+	ipAddr, err := ResolveTarget(target)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve destination IP address: %w", err)
+	}
+
+	conn, proto, err := listenTrace(ipAddr, opts.DontFragment)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &traceConn{
+		opts:    opts,
+		icmp:    conn,
+		proto:   proto,
+		dst:     ipAddr,
+		port:    opts.Port,
+		waiters: make(map[*traceWaiter]struct{}),
+		done:    make(chan struct{}),
+	}
+	go tc.readLoop()
+
+	switch opts.Method {
+	case TraceUDP:
+		if tc.port == 0 {
+			tc.port = DefaultUDPPort
+		}
+		network, address := "udp4", "0.0.0.0:0"
+		if proto == protocolICMPv6 {
+			network, address = "udp6", "[::]:0"
+		}
+		listenConfig := net.ListenConfig{
+			Control: func(network, address string, c syscall.RawConn) error {
+				if !opts.DontFragment {
+					return nil
+				}
+				var sockErr error
+				err := c.Control(func(fd uintptr) {
+					sockErr = setDontFragment(fd, proto == protocolICMPv6)
+				})
+				if err != nil {
+					return err
+				}
+				return sockErr
+			},
+		}
+		tc.udp, err = listenConfig.ListenPacket(context.Background(), network, address)
+		if err != nil {
+			tc.Close()
+			return nil, fmt.Errorf("unable to open UDP connection: %w", err)
+		}
+		tc.udpPort = tc.udp.LocalAddr().(*net.UDPAddr).Port
+	case TraceTCP:
+		if tc.port == 0 {
+			tc.port = DefaultTCPPort
+		}
+	}
+	if opts.Paris {
+		if tc.src, err = sourceIP(ipAddr); err != nil {
+			tc.Close()
+			return nil, err
+		}
+	}
+	return tc, nil
+}
+
+// nextIndex returns the number of probes sent before the next one.
+func (tc *traceConn) nextIndex() int {
+	return int(tc.index.Add(1)) - 1
+}
+
+// Close closes the sockets of the trace run, which also stops its read loop.
+func (tc *traceConn) Close() error {
+	if tc.udp != nil {
+		tc.udp.Close()
+	}
+	return tc.icmp.Close()
+}
+
+// listenTrace opens the ICMP socket used for probes to ipAddr, with DF set if dontFragment,
+// and returns it with its protocol number.
+func listenTrace(ipAddr *net.IPAddr, dontFragment bool) (*icmpSocket, int, error) {
+	proto := protocolICMPv4
+	if ipAddr.IP.To4() == nil {
+		proto = protocolICMPv6
+	}
+
+	conn, err := listenICMP(socketKey{proto: proto, dontFragment: dontFragment})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if proto == protocolICMPv6 {
+		// Only let through the replies a probe can cause, not neighbour discovery or router advertisements
+		var filter ipv6.ICMPFilter
+		filter.SetAll(true)
+		filter.Accept(ipv6.ICMPTypeTimeExceeded)
+		filter.Accept(ipv6.ICMPTypeEchoReply)
+		filter.Accept(ipv6.ICMPTypeDestinationUnreachable)
+		filter.Accept(ipv6.ICMPTypePacketTooBig)
+		conn.p6.SetICMPFilter(&filter)
+	}
+	return conn, proto, nil
+}
+
+// wait registers a probe that match recognises the answer of, sent at startTime.
+// It must be called before the probe is sent so that no answer can be missed.
+func (tc *traceConn) wait(startTime time.Time, match func(*icmp.Message) bool) *traceWaiter {
+	w := &traceWaiter{match: match, startTime: startTime, reply: make(chan Probe, 1)}
+	tc.mu.Lock()
+	tc.waiters[w] = struct{}{}
+	tc.mu.Unlock()
+	return w
+}
+
+// cancel unregisters w and returns the noise it saw.
+func (tc *traceConn) cancel(w *traceWaiter) int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	delete(tc.waiters, w)
+	return w.noise
+}
+
+// await waits for the answer to w until the timeout of the run has passed since the probe was sent
+// or ctx is cancelled, which both count as a timeout. It also returns the noise w saw.
+func (tc *traceConn) await(ctx context.Context, w *traceWaiter) (Probe, int, error) {
+	timer := time.NewTimer(time.Until(w.startTime.Add(tc.opts.Timeout)))
+	defer timer.Stop()
+
+	select {
+	case probe := <-w.reply:
+		return probe, tc.cancel(w), nil
+	case <-timer.C:
+	case <-ctx.Done():
+	case <-tc.done:
+		noise := tc.cancel(w)
+		if ctx.Err() != nil {
+			return Probe{Reply: ProbeTimeout}, noise, nil
+		}
+		return Probe{}, noise, fmt.Errorf("unable to read ICMP message: %w", tc.readErr)
+	}
+	return Probe{Reply: ProbeTimeout}, tc.cancel(w), nil
+}
+
+// readLoop reads ICMP messages until the socket is closed and delivers each one to the
+// waiting probe it belongs to. Messages that belong to no probe are counted as noise by every waiting probe.
+func (tc *traceConn) readLoop() {
+	defer close(tc.done)
+	buf := make([]byte, 65536) // Echo replies are as large as the probes
+	for {
+		n, _, peer, err := tc.icmp.ReadFrom(buf)
+		if err != nil {
+			tc.mu.Lock()
+			tc.readErr = err
+			tc.mu.Unlock()
+			return
+		}
+		received := time.Now()
+		raw := append([]byte(nil), buf[:n]...) // The parsed message and its extensions point into it
+
+		reply, err := icmp.ParseMessage(tc.proto, raw)
+		if err == nil && (reply.Type == ipv4.ICMPTypeEcho || reply.Type == ipv6.ICMPTypeEchoRequest) {
+			// Our own or another host's echo request seen on loopback, not an answer
+			continue
+		}
+
+		tc.mu.Lock()
+		matched := false
+		if err == nil {
+			for w := range tc.waiters {
+				if !w.match(reply) {
+					continue
+				}
+				if probe, ok := replyProbe(reply, raw, peer); ok {
+					probe.RTT = received.Sub(w.startTime)
+					delete(tc.waiters, w)
+					w.reply <- probe
+					matched = true
+				}
+				break
+			}
+		}
+		if !matched {
+			// Replies to earlier probes, concurrent pings or other tools share the raw socket
+			for w := range tc.waiters {
+				w.noise++
+			}
+		}
+		tc.mu.Unlock()
+	}
+}
+
+// replyProbe classifies an ICMP message that answers a probe.
+// It returns false for errors that quote the probe without answering it, such as Parameter Problem.
+func replyProbe(reply *icmp.Message, raw []byte, peer net.Addr) (Probe, bool) {
+	probe := Probe{Addr: peer.(*net.IPAddr).IP}
+	switch reply.Type {
+	case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded:
+		probe.Reply = ProbeTimeExceeded
+	case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
+		probe.Reply = ProbeEchoReply
+	case ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable, ipv6.ICMPTypePacketTooBig:
+		probe.Reply = ProbeUnreachable
+		probe.Err = icmpError(reply, raw, peer)
+	default:
+		return Probe{}, false
+	}
+	probe.MPLS, probe.Interfaces = replyExtensions(reply)
+	return probe, true
+}
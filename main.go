@@ -4,8 +4,12 @@ import (
 	"context"
 	"fmt"
 	"image/color"
+	"net/netip"
 	"os"
 	"pingotrace/internal/pingotrace"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,6 +18,7 @@ import (
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
@@ -74,7 +79,7 @@ func main() {
 	// Place Holder Text
 	placeHolderText1 = "Please enter up to 30 text lines:"
 	placeHolderText2 := "Working ..."
-	placeHolderText3 := "No IPv4 Found!"
+	placeHolderText3 := "No IP Found!"
 
 	// Loading custom theme
 	minRowVisible = 31
@@ -91,15 +96,42 @@ func main() {
 	hBoxTop := container.NewHBox()
 
 	var userInput string
+	pingOptions := pingotrace.DefaultPingOptions()
+
+	// DNS servers of DNS/PTR, DNS/PTR to IP and DNS QUERY, e.g. "system" or "8.8.8.8, 1.1.1.1";
+	// with several servers DNS/PTR compares their answers
+	dnsServerEntry := widget.NewSelectEntry([]string{"system", "8.8.8.8", "1.1.1.1", "9.9.9.9", "tls://1.1.1.1", "https://cloudflare-dns.com/dns-query", "system, 8.8.8.8, 1.1.1.1", "1.1.1.1, tls://1.1.1.1, https://cloudflare-dns.com/dns-query"})
+	dnsServerEntry.SetText("system")
+
+	// Record type of DNS QUERY, one of pingotrace.DNSTypes or "ALL"
+	dnsTypeEntry := widget.NewSelectEntry(append([]string{"ALL"}, pingotrace.DNSTypes...))
+	dnsTypeEntry.SetText("ALL")
+
+	// Probe method of TRACE, PINGOTRACE and ∞ TRACE, e.g. "ICMP", "UDP", "TCP:443" or "PARIS UDP"
+	traceMethodEntry := widget.NewSelectEntry([]string{"ICMP", "UDP", "TCP:80", "TCP:443", "PARIS ICMP", "PARIS UDP", "MDA ICMP", "MDA UDP"})
+	traceMethodEntry.SetText("ICMP")
+	// selectedTraceOptions returns the default trace options with the selected probe method
+	selectedTraceOptions := func() (pingotrace.TraceOptions, error) {
+		options := pingotrace.DefaultTraceOptions()
+		err := options.SetMethod(traceMethodEntry.Text)
+		return options, err
+	}
 
 	// Define the buttons
 	var btDNSBack *widget.Button
 	btDNSPTRtoIP := widget.NewButton("DNS/PTR to IP", func() {})
+	btFCrDNS := widget.NewButton("FCrDNS", func() {})
+	btDNSQuery := widget.NewButton("DNS QUERY", func() {})
+	btDNSTrace := widget.NewButton("DNS TRACE", func() {})
+	btTLS := widget.NewButton("TLS", func() {})
 	btPing := widget.NewButton("\u221E PING", func() {})
+	btPingOptions := widget.NewButton("PING OPTIONS", func() {})
 	btTrace := widget.NewButton("TRACE", func() {})
+	btPMTU := widget.NewButton("PMTU", func() {})
 	btPinGoTrace := widget.NewButton("PINGOTRACE", func() {})
 	btContinuousTrace := widget.NewButton("\u221E TRACE", func() {})
 	btIPConfig := widget.NewButton("IP CONFIG", func() {})
+	btFlushDNS := widget.NewButton("FLUSH DNS", func() {})
 	btStopBack := widget.NewButton("STOP", func() {})
 	btMainClear := widget.NewButton("CLEAR", func() {})
 	btLicense := widget.NewButton("LICENSE", func() {})
@@ -147,9 +179,13 @@ func main() {
 				vBoxCenter.RemoveAll()
 				vBoxCenter.Add(entryField)
 			} else { // If there's some input to work with
+				servers, err := pingotrace.ParseDNSServers(dnsServerEntry.Text)
+				if err != nil {
+					entryField.SetText(err.Error())
+					return
+				}
+
 				// Update the top horizontal box layout
-				keys := make([]string, len(parsedInput))
-				copy(keys, parsedInput)
 				hBoxTop.RemoveAll()
 				hBoxTop = container.NewHBox(btDNSBack, layout.NewSpacer(), btDark, btLight)
 				mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
@@ -165,14 +201,37 @@ func main() {
 				// Add the cancel function to a global slice to allow cancellation later
 				cancelFuncs = append(cancelFuncs, cancel)
 
+				if len(servers) > 1 {
+					// Comparison mode: the same query to every server, answers side by side
+					go func() {
+						comparisons := pingotrace.CompareDNS(ctx, parsedInput, servers, pingotrace.DefaultDNSTimeout)
+						if ctx.Err() != nil {
+							return
+						}
+						entryField.SetText(formatDNSComparisons(comparisons))
+					}()
+					return
+				}
+
 				// Create a channel for receiving DNS results
-				resultsChan := make(chan map[string][]interface{})
+				resultsChan := make(chan []pingotrace.LookupResult)
 				// Create a channel to signal when displaying of results is done
 				doneChan := make(chan bool)
 
+				// Show how far long lists of inputs have got, at most five times a second
+				opts := pingotrace.DefaultLookupOptions()
+				opts.Server = servers[0]
+				var lastProgress time.Time
+				opts.Progress = func(done, total int, result pingotrace.LookupResult) {
+					if ctx.Err() == nil && time.Since(lastProgress) >= 200*time.Millisecond {
+						lastProgress = time.Now()
+						entryField.SetText(fmt.Sprintf("Resolving %d of %d ...", done, total))
+					}
+				}
+
 				// Goroutine to fetch DNS/PTR results
 				go func() {
-					results, _ := pingotrace.DNSPTR(ctx, parsedInput)
+					results := pingotrace.BulkLookup(ctx, parsedInput, opts)
 					// Send results to the channel or return if the operation was cancelled
 					select {
 					case resultsChan <- results:
@@ -183,15 +242,16 @@ func main() {
 
 				// Goroutine to process and display the fetched results
 				go func() {
-					results := <-resultsChan
-					var orderedResults []string // Slice to hold results in order
-
-					// Loop through the original inputs to maintain order
-					for _, key := range keys {
-						if result, ok := results[key]; ok {
-							// Assuming result is a struct or similar that includes the address and success status
-							orderedResults = append(orderedResults, fmt.Sprintf("%s: %s", key, result[0]))
-						}
+					var results []pingotrace.LookupResult
+					select {
+					case results = <-resultsChan:
+					case <-ctx.Done():
+						return
+					}
+					var orderedResults []string // Results are in the order of the inputs
+
+					for _, result := range results {
+						orderedResults = append(orderedResults, result.String())
 					}
 
 					// Join the ordered results into a single string for display
@@ -237,6 +297,12 @@ func main() {
 				vBoxCenter.RemoveAll()
 				vBoxCenter.Add(entryField)
 			} else { // If there's some input to work with
+				servers, err := pingotrace.ParseDNSServers(dnsServerEntry.Text)
+				if err != nil {
+					entryField.SetText(err.Error())
+					return
+				}
+
 				// Update the top horizontal box layout
 				hBoxTop.RemoveAll()
 				hBoxTop = container.NewHBox(btDNSBack, layout.NewSpacer(), btDark, btLight)
@@ -258,9 +324,9 @@ func main() {
 				// Create a channel to signal when displaying of results is done
 				doneChan := make(chan bool)
 
-				// Goroutine to fetch IP addresses using DNSPTRtoIP function
+				// Goroutine to fetch IP addresses from the first DNS server
 				go func() {
-					ipAddresses := pingotrace.DNSPTRtoIP(ctx, parsedInput)
+					ipAddresses := pingotrace.DNSPTRtoIPVia(ctx, parsedInput, servers[0])
 					// Send IP addresses to the channel or return if the operation was cancelled
 					select {
 					case ipAddressChan <- ipAddresses:
@@ -271,7 +337,12 @@ func main() {
 
 				// Goroutine to process and display the fetched results
 				go func() {
-					ipAddresses := <-ipAddressChan
+					var ipAddresses []string
+					select {
+					case ipAddresses = <-ipAddressChan:
+					case <-ctx.Done():
+						return
+					}
 					if len(ipAddresses) == 0 {
 						entryField.SetText(placeHolderText3)
 					} else {
@@ -295,6 +366,369 @@ func main() {
 		}
 	})
 
+	btFCrDNS = widget.NewButton("FCrDNS", func() {
+		// Get the current text from the entry field
+		userInput = entryField.Text
+
+		// Parse the input using the pingotrace function
+		rawParsedInput := pingotrace.ParseInput(entryField.Text)
+
+		// Use type assertion to determine the type of parsed input (either string or slice of strings)
+		switch parsedInput := rawParsedInput.(type) {
+
+		case string: // If the parsed input is a string, treat it as an error message
+			entryField.SetText(parsedInput)
+			vBoxCenter.RemoveAll()
+			vBoxCenter.Add(entryField)
+			return // Exit the function after displaying the error
+
+		case []string: // If the parsed input is a slice of strings
+			// If the slice is empty (i.e., the user pressed the button without any input)
+			if len(parsedInput) == 0 {
+				entryField.SetText("")
+				vBoxCenter.RemoveAll()
+				vBoxCenter.Add(entryField)
+			} else { // If there's some input to work with
+				servers, err := pingotrace.ParseDNSServers(dnsServerEntry.Text)
+				if err != nil {
+					entryField.SetText(err.Error())
+					return
+				}
+
+				// Update the top horizontal box layout
+				hBoxTop.RemoveAll()
+				hBoxTop = container.NewHBox(btDNSBack, layout.NewSpacer(), btDark, btLight)
+				mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+				win.SetContent(mainBox)
+				win.Resize(fyne.NewSize(980, 537))
+
+				// Clear the entry field and set a new placeholder text
+				entryField.SetText("")
+				entryField.SetPlaceHolder(placeHolderText2)
+
+				// Create a cancellable context
+				ctx, cancel := context.WithCancel(context.Background())
+				// Add the cancel function to a global slice to allow cancellation later
+				cancelFuncs = append(cancelFuncs, cancel)
+
+				// Create a channel for the table once the results are ready
+				doneChan := make(chan fyne.CanvasObject)
+
+				// Goroutine to check every input with the first DNS server and show the results as a table
+				go func() {
+					results := pingotrace.FCrDNS(ctx, parsedInput, servers[0])
+					if ctx.Err() != nil {
+						return
+					}
+					header := []string{"Input", "Address", "PTR", "PTR resolves to", "FCrDNS", "Issues"}
+					var rows [][]string
+					for _, result := range results {
+						issues := result.Issues.String()
+						if result.Err != nil {
+							issues = strings.TrimPrefix(issues+", "+result.Err.Error(), ", ")
+						}
+						rows = append(rows, []string{result.Input, result.Address, strings.Join(result.PTRs, ", "),
+							strings.Join(result.Forward, ", "), result.Status(), issues})
+					}
+					select {
+					case doneChan <- newSortableTable(header, rows):
+					case <-ctx.Done():
+					}
+				}()
+
+				// Goroutine to show the table and reset the UI elements once the results are ready
+				go func() {
+					var table fyne.CanvasObject
+					select {
+					case table = <-doneChan:
+					case <-ctx.Done():
+						return
+					}
+					hBoxTop.RemoveAll()
+					hBoxTop = container.NewHBox(btDNSBack, layout.NewSpacer(), btDark, btLight)
+					mainBox = container.NewBorder(hBoxTop, nil, nil, nil, table)
+					win.SetContent(mainBox)
+					win.Resize(fyne.NewSize(980, 537))
+				}()
+			}
+		}
+	})
+
+	btDNSQuery = widget.NewButton("DNS QUERY", func() {
+		// Get the current text from the entry field
+		userInput = entryField.Text
+
+		// Parse the input using the pingotrace function
+		rawParsedInput := pingotrace.ParseInput(entryField.Text)
+
+		// Use type assertion to determine the type of parsed input (either string or slice of strings)
+		switch parsedInput := rawParsedInput.(type) {
+
+		case string: // If the parsed input is a string, treat it as an error message
+			entryField.SetText(parsedInput)
+			vBoxCenter.RemoveAll()
+			vBoxCenter.Add(entryField)
+			return // Exit the function after displaying the error
+
+		case []string: // If the parsed input is a slice of strings
+			// If the slice is empty (i.e., the user pressed the button without any input)
+			if len(parsedInput) == 0 {
+				entryField.SetText("")
+				vBoxCenter.RemoveAll()
+				vBoxCenter.Add(entryField)
+			} else { // If there's some input to work with
+				servers, err := pingotrace.ParseDNSServers(dnsServerEntry.Text)
+				if err != nil {
+					entryField.SetText(err.Error())
+					return
+				}
+				qtype := strings.ToUpper(strings.TrimSpace(dnsTypeEntry.Text))
+				if qtype != "ALL" && !contains(pingotrace.DNSTypes, qtype) {
+					entryField.SetText(fmt.Sprintf("Unsupported record type %q, choose ALL or one of %s",
+						dnsTypeEntry.Text, strings.Join(pingotrace.DNSTypes, ", ")))
+					return
+				}
+
+				// Update the top horizontal box layout
+				hBoxTop.RemoveAll()
+				hBoxTop = container.NewHBox(btDNSBack, layout.NewSpacer(), btDark, btLight)
+				mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+				win.SetContent(mainBox)
+				win.Resize(fyne.NewSize(980, 537))
+
+				// Clear the entry field and set a new placeholder text
+				entryField.SetText("")
+				entryField.SetPlaceHolder(placeHolderText2)
+
+				// Create a cancellable context
+				ctx, cancel := context.WithCancel(context.Background())
+				// Add the cancel function to a global slice to allow cancellation later
+				cancelFuncs = append(cancelFuncs, cancel)
+
+				// Create a channel to signal when displaying of results is done
+				doneChan := make(chan bool)
+
+				// Goroutine to query the inputs, DefaultParallel at once, and display the answers in input order
+				go func() {
+					blocks := make([]string, len(parsedInput))
+					pingotrace.ForEachParallel(len(parsedInput), pingotrace.DefaultParallel, func(i int) {
+						var answers []pingotrace.DNSAnswer
+						for _, server := range servers {
+							if qtype == "ALL" {
+								answers = append(answers, pingotrace.QueryDNSAll(ctx, server, parsedInput[i], pingotrace.DefaultDNSTimeout)...)
+							} else {
+								answers = append(answers, pingotrace.QueryDNS(ctx, server, parsedInput[i], qtype, pingotrace.DefaultDNSTimeout))
+							}
+						}
+						var lines []string
+						for _, answer := range answers {
+							lines = append(lines, formatDNSAnswer(answer))
+						}
+						blocks[i] = strings.Join(lines, "")
+					})
+					if ctx.Err() != nil {
+						return
+					}
+					entryField.SetText(strings.Join(blocks, "\n"))
+					select {
+					case doneChan <- true:
+					case <-ctx.Done():
+					}
+				}()
+
+				// Goroutine to finalize the UI updates once results are displayed
+				go func() {
+					select {
+					case <-doneChan:
+					case <-ctx.Done():
+						return
+					}
+					// Reset the UI elements
+					hBoxTop.RemoveAll()
+					hBoxTop = container.NewHBox(btDNSBack, layout.NewSpacer(), btDark, btLight)
+					mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+					win.SetContent(mainBox)
+					win.Resize(fyne.NewSize(980, 537))
+				}()
+			}
+		}
+	})
+
+	btDNSTrace = widget.NewButton("DNS TRACE", func() {
+		// Get the current text from the entry field
+		userInput = entryField.Text
+
+		// Parse the input using the pingotrace function
+		rawParsedInput := pingotrace.ParseInput(entryField.Text)
+
+		// Use type assertion to determine the type of parsed input (either string or slice of strings)
+		switch parsedInput := rawParsedInput.(type) {
+
+		case string: // If the parsed input is a string, treat it as an error message
+			entryField.SetText(parsedInput)
+			vBoxCenter.RemoveAll()
+			vBoxCenter.Add(entryField)
+			return // Exit the function after displaying the error
+
+		case []string: // If the parsed input is a slice of strings
+			// If the slice is empty (i.e., the user pressed the button without any input)
+			if len(parsedInput) == 0 {
+				entryField.SetText("")
+				vBoxCenter.RemoveAll()
+				vBoxCenter.Add(entryField)
+			} else { // If there's some input to work with
+				servers, err := pingotrace.ParseDNSServers(dnsServerEntry.Text)
+				if err != nil {
+					entryField.SetText(err.Error())
+					return
+				}
+				qtype := strings.ToUpper(strings.TrimSpace(dnsTypeEntry.Text))
+				if qtype != "ALL" && !contains(pingotrace.DNSTypes, qtype) {
+					entryField.SetText(fmt.Sprintf("Unsupported record type %q, choose ALL or one of %s",
+						dnsTypeEntry.Text, strings.Join(pingotrace.DNSTypes, ", ")))
+					return
+				}
+				// Name servers referred to without glue are looked up through the first DNS server
+				opts := pingotrace.DefaultDNSTraceOptions()
+				opts.Resolver = servers[0]
+
+				// Update the top horizontal box layout
+				hBoxTop.RemoveAll()
+				hBoxTop = container.NewHBox(btDNSBack, layout.NewSpacer(), btDark, btLight)
+				mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+				win.SetContent(mainBox)
+				win.Resize(fyne.NewSize(980, 537))
+
+				// Clear the entry field and set a new placeholder text
+				entryField.SetText("")
+				entryField.SetPlaceHolder(placeHolderText2)
+
+				// Create a cancellable context
+				ctx, cancel := context.WithCancel(context.Background())
+				// Add the cancel function to a global slice to allow cancellation later
+				cancelFuncs = append(cancelFuncs, cancel)
+
+				// Create a channel to signal when displaying of results is done
+				doneChan := make(chan bool)
+
+				// Goroutine to trace the inputs, DefaultParallel at once, and display the traces in input order
+				go func() {
+					blocks := make([]string, len(parsedInput))
+					pingotrace.ForEachParallel(len(parsedInput), pingotrace.DefaultParallel, func(i int) {
+						// ALL has no single delegation to follow, IPs are traced for their PTR record and names for A
+						traceType := qtype
+						if traceType == "ALL" && pingotrace.CheckIP(parsedInput[i]) {
+							traceType = "PTR"
+						} else if traceType == "ALL" {
+							traceType = "A"
+						}
+						blocks[i] = formatDNSTrace(pingotrace.TraceDNS(ctx, parsedInput[i], traceType, opts))
+					})
+					if ctx.Err() != nil {
+						return
+					}
+					entryField.SetText(strings.Join(blocks, "\n"))
+					select {
+					case doneChan <- true:
+					case <-ctx.Done():
+					}
+				}()
+
+				// Goroutine to finalize the UI updates once results are displayed
+				go func() {
+					select {
+					case <-doneChan:
+					case <-ctx.Done():
+						return
+					}
+					// Reset the UI elements
+					hBoxTop.RemoveAll()
+					hBoxTop = container.NewHBox(btDNSBack, layout.NewSpacer(), btDark, btLight)
+					mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+					win.SetContent(mainBox)
+					win.Resize(fyne.NewSize(980, 537))
+				}()
+			}
+		}
+	})
+
+	btTLS = widget.NewButton("TLS", func() {
+		// Get the current text from the entry field
+		userInput = entryField.Text
+
+		// Parse the input using the pingotrace function
+		rawParsedInput := pingotrace.ParseInput(entryField.Text)
+
+		// Use type assertion to determine the type of parsed input (either string or slice of strings)
+		switch parsedInput := rawParsedInput.(type) {
+
+		case string: // If the parsed input is a string, treat it as an error message
+			entryField.SetText(parsedInput)
+			vBoxCenter.RemoveAll()
+			vBoxCenter.Add(entryField)
+			return // Exit the function after displaying the error
+
+		case []string: // If the parsed input is a slice of strings
+			// If the slice is empty (i.e., the user pressed the button without any input)
+			if len(parsedInput) == 0 {
+				entryField.SetText("")
+				vBoxCenter.RemoveAll()
+				vBoxCenter.Add(entryField)
+			} else { // If there's some input to work with
+				// Update the top horizontal box layout
+				hBoxTop.RemoveAll()
+				hBoxTop = container.NewHBox(btDNSBack, layout.NewSpacer(), btDark, btLight)
+				mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+				win.SetContent(mainBox)
+				win.Resize(fyne.NewSize(980, 537))
+
+				// Clear the entry field and set a new placeholder text
+				entryField.SetText("")
+				entryField.SetPlaceHolder(placeHolderText2)
+
+				// Create a cancellable context
+				ctx, cancel := context.WithCancel(context.Background())
+				// Add the cancel function to a global slice to allow cancellation later
+				cancelFuncs = append(cancelFuncs, cancel)
+
+				// Create a channel to signal when displaying of results is done
+				doneChan := make(chan bool)
+
+				// Goroutine to inspect every target and display the results in input order
+				go func() {
+					results := pingotrace.InspectTLSAll(ctx, parsedInput, pingotrace.DefaultTLSTimeout)
+					if ctx.Err() != nil {
+						return
+					}
+					blocks := make([]string, len(results))
+					for i, result := range results {
+						blocks[i] = formatTLS(result)
+					}
+					entryField.SetText(strings.Join(blocks, "\n"))
+					select {
+					case doneChan <- true:
+					case <-ctx.Done():
+					}
+				}()
+
+				// Goroutine to finalize the UI updates once results are displayed
+				go func() {
+					select {
+					case <-doneChan:
+					case <-ctx.Done():
+						return
+					}
+					// Reset the UI elements
+					hBoxTop.RemoveAll()
+					hBoxTop = container.NewHBox(btDNSBack, layout.NewSpacer(), btDark, btLight)
+					mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+					win.SetContent(mainBox)
+					win.Resize(fyne.NewSize(980, 537))
+				}()
+			}
+		}
+	})
+
 	btDNSBack = widget.NewButton("BACK", func() {
 		// 1. Cancel any ongoing operations
 		for _, cancel := range cancelFuncs {
@@ -304,7 +738,7 @@ func main() {
 		cancelFuncs = []context.CancelFunc{}
 
 		// 2. Reset the UI
-		hBoxTop = container.NewHBox(btParser, btDNSPTRLookup, btDNSPTRtoIP, btPing, btTrace, btPinGoTrace, btContinuousTrace, btIPConfig, btMainClear, btLicense, layout.NewSpacer(), btDark, btLight)
+		hBoxTop = container.NewHBox(btParser, btDNSPTRLookup, btDNSPTRtoIP, dnsServerEntry, btFCrDNS, btDNSQuery, dnsTypeEntry, btDNSTrace, btTLS, btPing, btPingOptions, btTrace, traceMethodEntry, btPMTU, btPinGoTrace, btContinuousTrace, btIPConfig, btFlushDNS, btMainClear, btLicense, layout.NewSpacer(), btDark, btLight)
 		vBoxCenter.Objects = nil
 		vBoxCenter.Add(entryField)
 		mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
@@ -328,7 +762,7 @@ func main() {
 		vBoxCenter.RemoveAll()
 		entryField.SetText(userInput)
 		vBoxCenter.Add(entryField)
-		hBoxTop = container.NewHBox(btParser, btDNSPTRLookup, btDNSPTRtoIP, btPing, btTrace, btPinGoTrace, btContinuousTrace, btIPConfig, btMainClear, btLicense, layout.NewSpacer(), btDark, btLight)
+		hBoxTop = container.NewHBox(btParser, btDNSPTRLookup, btDNSPTRtoIP, dnsServerEntry, btFCrDNS, btDNSQuery, dnsTypeEntry, btDNSTrace, btTLS, btPing, btPingOptions, btTrace, traceMethodEntry, btPMTU, btPinGoTrace, btContinuousTrace, btIPConfig, btFlushDNS, btMainClear, btLicense, layout.NewSpacer(), btDark, btLight)
 		mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
 		win.SetContent(mainBox)
 		win.Resize(fyne.NewSize(980, 537))
@@ -409,73 +843,88 @@ func main() {
 				entryField.SetPlaceHolder(placeHolderText2)
 				vBoxCenter.Add(entryField)
 				tableListPing := []*fyne.Container{}
-				dnsPTRResults, dnsPTRKeys := pingotrace.DNSPTR(ctx, parsedInput)
-				ipv4Addresses := []string{}
+				statsListPing := []*widget.Label{}
+				dnsPTRResults := pingotrace.RemoveDuplicateLookups(pingotrace.DNSPTR(ctx, parsedInput))
+				ipAddresses := []string{}
 
-				dnsPTRResults = pingotrace.RemoveDuplicatesMap(dnsPTRResults)
 				// Remove field as Ping can display info
-				vBoxCenter.RemoveAll()
-				for dnsPTRKey := range dnsPTRKeys {
-					for key, value := range dnsPTRResults {
-						if dnsPTRKeys[dnsPTRKey] == key {
-							var ipAddr, host string
-							if pingotrace.CheckIPv4(key) {
-								ipAddr = key
-								host = value[0].(string)
-								if value[len(value)-1] == false {
-									labelTextPing := fmt.Sprintf("Pinging %s [%s] with 32 bytes of data:", ipAddr, ipAddr)
-									vBoxCenter.Add(widget.NewLabel(labelTextPing))
-								} else {
-									labelTextPing := fmt.Sprintf("Pinging %s [%s] with 32 bytes of data:", ipAddr, host)
-									vBoxCenter.Add(widget.NewLabel(labelTextPing))
-								}
-								if !contains(ipv4Addresses, ipAddr) {
-									ipv4Addresses = append(ipv4Addresses, ipAddr)
-								}
-							} else {
-								if value[len(value)-1] == false {
-									labelTextPing := fmt.Sprintf("%s: %s", key, value[0].(string))
-									vBoxCenter.Add(widget.NewLabel(labelTextPing))
-									hashRow := strings.Repeat("#", numOfHashes)
-									hashLabel := widget.NewLabel(hashRow)
-									vBoxCenter.Add(hashLabel)
-									continue
-								} else {
-									ipAddr = value[0].(string)
-									host = key
-									labelTextPing := fmt.Sprintf("Pinging %s [%s] with 32 bytes of data:", host, ipAddr)
-									vBoxCenter.Add(widget.NewLabel(labelTextPing))
-									if !contains(ipv4Addresses, ipAddr) {
-										ipv4Addresses = append(ipv4Addresses, ipAddr)
-									}
-								}
-							}
-							tablePing := createTable(2, numOfColumns)
-							vBoxCenter.Add(tablePing)
-							tableListPing = append(tableListPing, tablePing)
+				vBoxCenter.RemoveAll()
+				for _, result := range dnsPTRResults {
+					key := result.Input
+					var ipAddr, host string
+					// A URL gets HTTP probes, a target with a port TCP connect pings instead of echo requests
+					targetURL := pingotrace.ParseURL(key)
+					_, port := pingotrace.SplitPort(key)
+					pingLabel := func(name, address string) string {
+						if targetURL != nil {
+							return fmt.Sprintf("Requesting %s [%s]:", targetURL, address)
+						}
+						if port > 0 {
+							return fmt.Sprintf("Connecting to %s [%s] on TCP port %d:", name, address, port)
+						}
+						return fmt.Sprintf("Pinging %s [%s] with %d bytes of data:", name, address, pingOptions.Size)
+					}
+					if pingotrace.CheckIP(key) {
+						ipAddr = key
+						host = result.Answer()
+						address, _ := pingotrace.SplitPort(key)
+						if !result.OK() {
+							vBoxCenter.Add(widget.NewLabel(pingLabel(address, address)))
+						} else {
+							vBoxCenter.Add(widget.NewLabel(pingLabel(address, host)))
+						}
+						if !contains(ipAddresses, ipAddr) {
+							ipAddresses = append(ipAddresses, ipAddr)
+						}
+					} else {
+						if !result.OK() {
+							labelTextPing := result.String()
+							vBoxCenter.Add(widget.NewLabel(labelTextPing))
 							hashRow := strings.Repeat("#", numOfHashes)
 							hashLabel := widget.NewLabel(hashRow)
 							vBoxCenter.Add(hashLabel)
+							continue
+						} else {
+							ipAddr = pingotrace.JoinPort(result.Answer(), port)
+							if targetURL != nil {
+								ipAddr = key
+							}
+							host, _ = pingotrace.SplitPort(key)
+							vBoxCenter.Add(widget.NewLabel(pingLabel(host, result.Answer())))
+							if !contains(ipAddresses, ipAddr) {
+								ipAddresses = append(ipAddresses, ipAddr)
+							}
 						}
 					}
+					tablePing := createTable(2, numOfColumns)
+					vBoxCenter.Add(tablePing)
+					tableListPing = append(tableListPing, tablePing)
+					statsLabel := widget.NewLabel("")
+					vBoxCenter.Add(statsLabel)
+					statsListPing = append(statsListPing, statsLabel)
+					hashRow := strings.Repeat("#", numOfHashes)
+					hashLabel := widget.NewLabel(hashRow)
+					vBoxCenter.Add(hashLabel)
 				}
 
 				// Create an order channel with a buffer size equal to the number of goroutines
-				pingOrderChan := make(chan struct{}, len(ipv4Addresses))
+				pingOrderChan := make(chan struct{}, len(ipAddresses))
 
 				// Preload the channel with an empty struct for each goroutine
-				for i := 0; i < len(ipv4Addresses); i++ {
+				for i := 0; i < len(ipAddresses); i++ {
 					pingOrderChan <- struct{}{}
 				}
 
 				var wgPing sync.WaitGroup
 				chPing := make(chan string)
-				for index, ipAddress := range ipv4Addresses {
+				for index, ipAddress := range ipAddresses {
 					wgPing.Add(1)
-					go func(ipAddress string, table *fyne.Container, ctx context.Context, index int) {
+					go func(ipAddress string, table *fyne.Container, statsLabel *widget.Label, ctx context.Context, index int) {
 						defer wgPing.Done()
+						pingOpts := pingOptions
+						var pingStats pingotrace.PingStats
 						cellIndex := 0
-						pingSleepDuration := 1 * time.Second
+						pingSleepDuration := pingOpts.Interval
 						for {
 							select {
 							case <-ctx.Done():
@@ -483,20 +932,24 @@ func main() {
 							default:
 								<-pingOrderChan // Wait for our turn to update
 
-								rawDurationTime, _ := pingotrace.Ping(ipAddress)
-								pingResult := ""
-
-								if rawDurationTime > 0 && rawDurationTime < 500*time.Microsecond { // Less than 0.5 ms
-									pingResult = "< 1 ms"
-								} else if rawDurationTime >= 500*time.Microsecond {
-									pingResult = fmt.Sprintf("%.0f ms", float64(rawDurationTime)/float64(time.Millisecond))
-								} else { // If duration is 0 or error
-									pingResult = "TIMEOUT"
+								var pingResult pingotrace.PingResult
+								var resultLabel, statsText string
+								if pingotrace.ParseURL(ipAddress) != nil {
+									httpResult := pingotrace.HTTPProbe(ctx, ipAddress, pingOpts.Timeout)
+									pingResult, resultLabel = httpResult.PingResult, httpResult.Label()
+									pingStats.Add(pingResult)
+									statsText = pingStats.Summary().String() + "\nLast: " + httpResult.String()
+								} else {
+									pingResult = pingotrace.Ping(ipAddress, pingOpts)
+									resultLabel = pingResult.Label()
+									pingStats.Add(pingResult)
+									statsText = pingStats.Summary().String()
 								}
+								statsLabel.SetText(statsText)
 
 								// pingDisplayMutex.Lock()
 								statusLabel := table.Objects[cellIndex].(*canvas.Text)
-								if rawDurationTime > 0 {
+								if pingResult.Success() {
 									statusLabel.Text = "   !"
 									statusLabel.Color = color.RGBA{R: 0, G: 255, B: 0, A: 255}
 								} else {
@@ -508,13 +961,13 @@ func main() {
 								}
 
 								label := table.Objects[cellIndex+numOfColumns].(*canvas.Text)
-								if rawDurationTime > 0 {
+								if pingResult.Success() {
 									label.Color = color.RGBA{R: 0, G: 255, B: 0, A: 255}
 								} else {
 									label.Color = color.RGBA{R: 255, G: 0, B: 0, A: 255}
 								}
 
-								label.Text = "   " + pingResult
+								label.Text = "   " + resultLabel
 								win.Canvas().Refresh(label)
 								cellIndex++
 								if cellIndex == numOfColumns {
@@ -532,9 +985,13 @@ func main() {
 								time.Sleep(pingSleepDuration)
 								// Signal that we're done updating
 								pingOrderChan <- struct{}{}
+								// Stop once the configured number of requests has been sent
+								if pingOpts.Count > 0 && pingStats.Summary().Sent >= pingOpts.Count {
+									return
+								}
 							}
 						}
-					}(ipAddress, tableListPing[index], ctx, index)
+					}(ipAddress, tableListPing[index], statsListPing[index], ctx, index)
 				}
 				go func() {
 					wgPing.Wait()
@@ -544,8 +1001,82 @@ func main() {
 		}
 	})
 
+	btPingOptions = widget.NewButton("PING OPTIONS", func() {
+		// Entries pre-filled with the current options
+		sizeEntry := widget.NewEntry()
+		sizeEntry.SetText(strconv.Itoa(pingOptions.Size))
+		patternEntry := widget.NewEntry()
+		patternEntry.SetText(string(pingOptions.Pattern))
+		intervalEntry := widget.NewEntry()
+		intervalEntry.SetText(strconv.FormatInt(pingOptions.Interval.Milliseconds(), 10))
+		countEntry := widget.NewEntry()
+		countEntry.SetText(strconv.Itoa(pingOptions.Count))
+		timeoutEntry := widget.NewEntry()
+		timeoutEntry.SetText(strconv.FormatInt(pingOptions.Timeout.Milliseconds(), 10))
+		ttlEntry := widget.NewEntry()
+		ttlEntry.SetText(strconv.Itoa(pingOptions.TTL))
+		dscpEntry := widget.NewEntry()
+		dscpEntry.SetText(strconv.Itoa(pingOptions.TOS >> 2))
+		dfCheck := widget.NewCheck("", nil)
+		dfCheck.SetChecked(pingOptions.DontFragment)
+
+		formItems := []*widget.FormItem{
+			widget.NewFormItem("Payload size (bytes)", sizeEntry),
+			widget.NewFormItem("Payload pattern", patternEntry),
+			widget.NewFormItem("Interval (ms)", intervalEntry),
+			widget.NewFormItem("Count (0 = continuous)", countEntry),
+			widget.NewFormItem("Timeout (ms)", timeoutEntry),
+			widget.NewFormItem("TTL (0 = system default)", ttlEntry),
+			widget.NewFormItem("DSCP (0-63)", dscpEntry),
+			widget.NewFormItem("Don't fragment", dfCheck),
+		}
+
+		dialog.ShowForm("Ping options", "SAVE", "CANCEL", formItems, func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			// Parse every numeric entry, stopping at the first invalid one
+			var values [6]int
+			numericItems := []*widget.FormItem{formItems[0], formItems[2], formItems[3], formItems[4], formItems[5], formItems[6]}
+			for i, item := range numericItems {
+				text := item.Widget.(*widget.Entry).Text
+				value, err := strconv.Atoi(strings.TrimSpace(text))
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("%s: %q is not a number", item.Text, text), win)
+					return
+				}
+				values[i] = value
+			}
+			if values[5] < 0 || values[5] > 63 {
+				dialog.ShowError(fmt.Errorf("DSCP %d is out of range 0-63", values[5]), win)
+				return
+			}
+
+			newOptions := pingotrace.PingOptions{
+				Size:         values[0],
+				Pattern:      []byte(patternEntry.Text),
+				Interval:     time.Duration(values[1]) * time.Millisecond,
+				Count:        values[2],
+				Timeout:      time.Duration(values[3]) * time.Millisecond,
+				TTL:          values[4],
+				TOS:          values[5] << 2,
+				DontFragment: dfCheck.Checked,
+			}
+			if err := newOptions.Validate(); err != nil {
+				dialog.ShowError(err, win)
+				return
+			}
+			pingOptions = newOptions
+		}, win)
+	})
+
 	// traceResultChans := make(map[string]chan []string)
 	btTrace = widget.NewButton("TRACE", func() {
+		traceOptions, err := selectedTraceOptions()
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
 		// Stop all previous traceroute goroutines
 		for _, cancel := range cancelFuncs {
 			cancel()
@@ -581,85 +1112,83 @@ func main() {
 				entryField.SetText("")
 				entryField.SetPlaceHolder(placeHolderText2)
 				vBoxCenter.Add(entryField)
-				results, keys := pingotrace.DNSPTR(ctx, parsedInput)
+				// Only the first target is traced
+				result := pingotrace.DNSPTR(ctx, parsedInput[:1])[0]
 
 				var ipAddr, host string
-				for key, value := range results {
-					if key == keys[0] {
-						if pingotrace.CheckIPv4(key) {
-							ipAddr = key
-							host = value[0].(string)
-							if value[len(value)-1] == false {
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, ipAddr))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							} else {
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, host))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							}
-
-						} else {
-							if value[len(value)-1] == false {
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetPlaceHolder(fmt.Sprintf("%s: %s", key, value[0].(string)))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								// log.Println(key, value, "print3")
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							} else {
-								host = key
-								ipAddr = value[0].(string)
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", host, ipAddr))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								// log.Println(key, value, "print4")
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							}
+				key := result.Input
+				if pingotrace.CheckIP(key) {
+					ipAddr = key
+					host = result.Answer()
+					if !result.OK() {
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, ipAddr))
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
+					} else {
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, host))
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
+					}
 
-						}
-						break
+				} else {
+					if !result.OK() {
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetPlaceHolder(result.String())
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						// log.Println(key, result, "print3")
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
+					} else {
+						host = key
+						ipAddr = result.Answer()
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", host, ipAddr))
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						// log.Println(key, result, "print4")
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
 					}
+
 				}
 
 				if ipAddr != "" {
-					maxHops := 30
-					timeout := 1 * time.Second
+					tracer := pingotrace.NewTracer(traceOptions)
 
-					traceOutputChan := make(chan []string, maxHops)
+					traceOutputChan := make(chan pingotrace.Hop, traceOptions.MaxHops)
 					// Goroutine
 					go func() {
-						pingotrace.Trace(ipAddr, maxHops, timeout, ctx, traceOutputChan)
+						if err := tracer.Run(ctx, ipAddr, traceOutputChan); err != nil {
+							entryField.SetText(entryField.Text + err.Error() + "\n")
+						}
 					}()
 					go func() {
 						for {
 							select {
-							case line, ok := <-traceOutputChan:
+							case hop, ok := <-traceOutputChan:
 								if !ok {
 									return
 								}
@@ -667,16 +1196,7 @@ func main() {
 									cancel() // cancel the context
 									return
 								}
-								result := fmt.Sprintf("%2s\t", line[0])
-								for i := 2; i < len(line); i++ {
-									if line[i] == "*" {
-										result += fmt.Sprintf("%-10s      \t", line[i]) // Add 6 more spaces after "*"
-									} else {
-										result += fmt.Sprintf("%-10s\t", line[i])
-									}
-								}
-								result += fmt.Sprintf("%-45s", line[1])
-								entryField.SetText(entryField.Text + result + "\n")
+								entryField.SetText(entryField.Text + formatHop(hop) + "\n")
 								entryField.Refresh() // Notify Fyne to repaint the widget
 							case <-ctx.Done():
 								// context canceled
@@ -689,7 +1209,71 @@ func main() {
 		}
 	})
 
+	btPMTU = widget.NewButton("PMTU", func() {
+		traceOptions, err := selectedTraceOptions()
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
+		// Stop all previous goroutines
+		for _, cancel := range cancelFuncs {
+			cancel()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelFuncs = append(cancelFuncs, cancel)
+
+		// Save previous user input into variable
+		userInput = entryField.Text
+		rawParsedInput := pingotrace.ParseInput(entryField.Text)
+
+		switch parsedInput := rawParsedInput.(type) {
+		case string: // this is an error message
+			entryField.SetText(parsedInput)
+			vBoxCenter.RemoveAll()
+			vBoxCenter.Add(entryField)
+			return
+
+		case []string:
+			if len(parsedInput) == 0 {
+				entryField.SetText("")
+				vBoxCenter.RemoveAll()
+				vBoxCenter.Add(entryField)
+				return
+			}
+			// Only the first target is measured, like TRACE
+			target := parsedInput[0]
+			vBoxCenter.RemoveAll()
+			win.Resize(fyne.NewSize(980, 537))
+			entryField = newTappableEntry("")
+			entryField.SetText(fmt.Sprintf("Path MTU discovery to %s:\n\n", target))
+			entryField.SetMinRowsVisible(minRowVisible)
+			vBoxCenter.Add(entryField)
+			hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+			mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+			win.SetContent(mainBox)
+			win.Resize(fyne.NewSize(980, 537))
+
+			go func() {
+				result, err := pingotrace.NewTracer(traceOptions).DiscoverPMTU(ctx, target)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					entryField.SetText(entryField.Text + err.Error() + "\n")
+					return
+				}
+				entryField.SetText(entryField.Text + formatPMTU(result))
+				entryField.Refresh()
+			}()
+		}
+	})
+
 	btPinGoTrace = widget.NewButton("PINGOTRACE", func() {
+		traceOptions, err := selectedTraceOptions()
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
 		// Stop all previous traceroute goroutines
 		for _, cancel := range cancelFuncs {
 			cancel()
@@ -726,93 +1310,89 @@ func main() {
 				entryField.SetText("")
 				entryField.SetPlaceHolder(placeHolderText2)
 				vBoxCenter.Add(entryField)
-				results, keys := pingotrace.DNSPTR(ctx, parsedInput)
+				// Only the first target is traced
+				result := pingotrace.DNSPTR(ctx, parsedInput[:1])[0]
 
 				var ipAddr, host string
-				for key, value := range results {
-					if key == keys[0] {
-						if pingotrace.CheckIPv4(key) {
-							ipAddr = key
-							host = value[0].(string)
-							if value[len(value)-1] == false {
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, ipAddr))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							} else {
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, host))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							}
-						} else {
-							if value[len(value)-1] == false {
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetPlaceHolder(fmt.Sprintf("%s: %s", key, value[0].(string)))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							} else {
-								host = key
-								ipAddr = value[0].(string)
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", host, ipAddr))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							}
-						}
-						break
+				key := result.Input
+				if pingotrace.CheckIP(key) {
+					ipAddr = key
+					host = result.Answer()
+					if !result.OK() {
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, ipAddr))
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
+					} else {
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, host))
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
+					}
+				} else {
+					if !result.OK() {
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetPlaceHolder(result.String())
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
+					} else {
+						host = key
+						ipAddr = result.Answer()
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", host, ipAddr))
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
 					}
 				}
 
 				if ipAddr != "" {
-					maxHops := 30
-					timeout := 1 * time.Second
+					tracer := pingotrace.NewTracer(traceOptions)
 
 					// Wrap the traceroute function to receive the output
-					traceOutputChan := make(chan []string, maxHops)
+					traceOutputChan := make(chan pingotrace.Hop, traceOptions.MaxHops)
 
 					var wgPinGoPath sync.WaitGroup
 					// Goroutine
 					wgPinGoPath.Add(1)
 					go func() {
 						defer wgPinGoPath.Done()
-						pingotrace.PinGoTrace(ipAddr, maxHops, timeout, ctx, traceOutputChan)
-						close(traceOutputChan)
+						if err := tracer.Run(ctx, ipAddr, traceOutputChan); err != nil {
+							entryField.SetText(entryField.Text + err.Error() + "\n")
+						}
 					}()
 
 					var dstHops []string
-					// dstHopsCh := make(chan string)
 
 					wgPinGoPath.Add(1)
 					go func() {
 						defer wgPinGoPath.Done()
 						for {
 							select {
-							case traceLine, ok := <-traceOutputChan:
+							case hop, ok := <-traceOutputChan:
 								if !ok {
 									return
 								}
@@ -821,36 +1401,16 @@ func main() {
 									return
 								}
 
-								result := fmt.Sprintf("%2s\t", traceLine[0])
-								for i := 2; i < len(traceLine); i++ {
-									if traceLine[i] == "*" {
-										result += fmt.Sprintf("%-10s      \t", traceLine[i]) // Add 6 more spaces after "*"
-									} else {
-										result += fmt.Sprintf("%-10s\t", traceLine[i])
-									}
-								}
-								result += fmt.Sprintf("%-45s", traceLine[1])
-
 								// Assuming you're using Fyne, updates to GUI components should be done in the main thread.
 								// If Fyne provides a mechanism to run on the main thread (like RunOnMain), use it.
-								entryField.SetText(entryField.Text + result + "\n")
+								entryField.SetText(entryField.Text + formatHop(hop) + "\n")
 								entryField.Refresh() // Notify Fyne to repaint the widget
 
-								// Combine all elements in the line slice into a single string
-								// Check if the whole line is a valid IPv4
-								wholeLine := strings.Join(traceLine, " ")
-								ipAddr := pingotrace.ParseIPv4(wholeLine)
-								if pingotrace.CheckIPv4(ipAddr) {
-									// mu.Lock()
-									ipAddr = pingotrace.ParseIPv4(ipAddr)
-									// dstHopsCh <- ipAddr
-									dstHops = append(dstHops, ipAddr)
-									// dstHopsCh <- dstHops
-
+								// Keep every router that answered, including all load-balanced ones, for the follow-up ping
+								for _, responder := range hop.Responders {
+									dstHops = append(dstHops, responder.Addr.String())
 								}
-								// fmt.Printf("first %v", dstHops)
 							case <-ctx.Done():
-								// fmt.Println("Context cancellation detected!")
 								dstHops = []string{}
 								return
 							}
@@ -887,73 +1447,75 @@ func main() {
 							entryField.SetMinRowsVisible(minRowVisible)
 							vBoxCenter.Add(entryField)
 							tableListPing := []*fyne.Container{}
-							dnsPTRResults, dnsPTRKeys := pingotrace.DNSPTR(ctx, parsedInput)
-							ipv4Addresses := []string{}
+							statsListPing := []*widget.Label{}
+							dnsPTRResults := pingotrace.RemoveDuplicateLookups(pingotrace.DNSPTR(ctx, parsedInput))
+							ipAddresses := []string{}
 
-							dnsPTRResults = pingotrace.RemoveDuplicatesMap(dnsPTRResults)
 							// Remove field as Ping can display info
 							vBoxCenter.RemoveAll()
-							for dnsPTRKey := range dnsPTRKeys {
-								for key, value := range dnsPTRResults {
-									if dnsPTRKeys[dnsPTRKey] == key {
-										var ipAddr, host string
-										if pingotrace.CheckIPv4(key) {
-											ipAddr = key
-											host = value[0].(string)
-											if value[len(value)-1] == false {
-												labelTextPing := fmt.Sprintf("Pinging %s [%s] with 32 bytes of data:", ipAddr, ipAddr)
-												vBoxCenter.Add(widget.NewLabel(labelTextPing))
-											} else {
-												labelTextPing := fmt.Sprintf("Pinging %s [%s] with 32 bytes of data:", ipAddr, host)
-												vBoxCenter.Add(widget.NewLabel(labelTextPing))
-											}
-											if !contains(ipv4Addresses, ipAddr) {
-												ipv4Addresses = append(ipv4Addresses, ipAddr)
-											}
-										} else {
-											if value[len(value)-1] == false {
-												labelTextPing := fmt.Sprintf("%s: %s", key, value[0].(string))
-												vBoxCenter.Add(widget.NewLabel(labelTextPing))
-												hashRow := strings.Repeat("#", numOfHashes)
-												hashLabel := widget.NewLabel(hashRow)
-												vBoxCenter.Add(hashLabel)
-												continue
-											} else {
-												ipAddr = value[0].(string)
-												host = key
-												labelTextPing := fmt.Sprintf("Pinging %s [%s] with 32 bytes of data:", host, ipAddr)
-												vBoxCenter.Add(widget.NewLabel(labelTextPing))
-												if !contains(ipv4Addresses, ipAddr) {
-													ipv4Addresses = append(ipv4Addresses, ipAddr)
-												}
-											}
-										}
-										tablePing := createTable(2, numOfColumns)
-										vBoxCenter.Add(tablePing)
-										tableListPing = append(tableListPing, tablePing)
+							for _, result := range dnsPTRResults {
+								key := result.Input
+								var ipAddr, host string
+								if pingotrace.CheckIP(key) {
+									ipAddr = key
+									host = result.Answer()
+									if !result.OK() {
+										labelTextPing := fmt.Sprintf("Pinging %s [%s] with %d bytes of data:", ipAddr, ipAddr, pingOptions.Size)
+										vBoxCenter.Add(widget.NewLabel(labelTextPing))
+									} else {
+										labelTextPing := fmt.Sprintf("Pinging %s [%s] with %d bytes of data:", ipAddr, host, pingOptions.Size)
+										vBoxCenter.Add(widget.NewLabel(labelTextPing))
+									}
+									if !contains(ipAddresses, ipAddr) {
+										ipAddresses = append(ipAddresses, ipAddr)
+									}
+								} else {
+									if !result.OK() {
+										labelTextPing := result.String()
+										vBoxCenter.Add(widget.NewLabel(labelTextPing))
 										hashRow := strings.Repeat("#", numOfHashes)
 										hashLabel := widget.NewLabel(hashRow)
 										vBoxCenter.Add(hashLabel)
+										continue
+									} else {
+										ipAddr = result.Answer()
+										host = key
+										labelTextPing := fmt.Sprintf("Pinging %s [%s] with %d bytes of data:", host, ipAddr, pingOptions.Size)
+										vBoxCenter.Add(widget.NewLabel(labelTextPing))
+										if !contains(ipAddresses, ipAddr) {
+											ipAddresses = append(ipAddresses, ipAddr)
+										}
 									}
 								}
+								tablePing := createTable(2, numOfColumns)
+								vBoxCenter.Add(tablePing)
+								tableListPing = append(tableListPing, tablePing)
+								statsLabel := widget.NewLabel("")
+								vBoxCenter.Add(statsLabel)
+								statsListPing = append(statsListPing, statsLabel)
+								hashRow := strings.Repeat("#", numOfHashes)
+								hashLabel := widget.NewLabel(hashRow)
+								vBoxCenter.Add(hashLabel)
 							}
 
 							// Create an order channel with a buffer size equal to the number of goroutines
-							orderChan := make(chan struct{}, len(ipv4Addresses))
+							orderChan := make(chan struct{}, len(ipAddresses))
 
 							// Preload the channel with an empty struct for each goroutine
-							for i := 0; i < len(ipv4Addresses); i++ {
+							for i := 0; i < len(ipAddresses); i++ {
 								orderChan <- struct{}{}
 							}
 
 							var wgPinGoPath sync.WaitGroup
 							chPinGoPath := make(chan string)
-							for index, ipAddress := range ipv4Addresses {
+							for index, ipAddress := range ipAddresses {
 								wgPinGoPath.Add(1)
-								go func(ipAddress string, table *fyne.Container, ctx context.Context, index int) {
+								go func(ipAddress string, table *fyne.Container, statsLabel *widget.Label, ctx context.Context, index int) {
 									defer wgPinGoPath.Done()
+									pingOpts := pingOptions
+									var pingStats pingotrace.PingStats
 									cellIndex := 0
-									pingSleepDuration := 1 * time.Second
+									pingSleepDuration := pingOpts.Interval
 									for {
 										select {
 										case <-ctx.Done():
@@ -961,19 +1523,12 @@ func main() {
 										default:
 											<-orderChan // Wait for our turn to update
 
-											rawDurationTime, _ := pingotrace.Ping(ipAddress)
-											pingResult := ""
-
-											if rawDurationTime > 0 && rawDurationTime < 500*time.Microsecond { // Less than 0.5 ms
-												pingResult = "< 1 ms"
-											} else if rawDurationTime >= 500*time.Microsecond {
-												pingResult = fmt.Sprintf("%.0f ms", float64(rawDurationTime)/float64(time.Millisecond))
-											} else { // If duration is 0 or error
-												pingResult = "TIMEOUT"
-											}
+											pingResult := pingotrace.Ping(ipAddress, pingOpts)
+											pingStats.Add(pingResult)
+											statsLabel.SetText(pingStats.Summary().String())
 
 											statusLabel := table.Objects[cellIndex].(*canvas.Text)
-											if rawDurationTime > 0 {
+											if pingResult.Success() {
 												statusLabel.Text = "   !"
 												statusLabel.Color = color.RGBA{R: 0, G: 255, B: 0, A: 255}
 											} else {
@@ -985,13 +1540,13 @@ func main() {
 											}
 
 											label := table.Objects[cellIndex+numOfColumns].(*canvas.Text)
-											if rawDurationTime > 0 {
+											if pingResult.Success() {
 												label.Color = color.RGBA{R: 0, G: 255, B: 0, A: 255}
 											} else {
 												label.Color = color.RGBA{R: 255, G: 0, B: 0, A: 255}
 											}
 
-											label.Text = "   " + pingResult
+											label.Text = "   " + pingResult.Label()
 											win.Canvas().Refresh(label)
 											cellIndex++
 											if cellIndex == numOfColumns {
@@ -1008,9 +1563,13 @@ func main() {
 											}
 											time.Sleep(pingSleepDuration)
 											orderChan <- struct{}{}
+											// Stop once the configured number of requests has been sent
+											if pingOpts.Count > 0 && pingStats.Summary().Sent >= pingOpts.Count {
+												return
+											}
 										}
 									}
-								}(ipAddress, tableListPing[index], ctx, index)
+								}(ipAddress, tableListPing[index], statsListPing[index], ctx, index)
 							}
 							go func() {
 								wgPinGoPath.Wait()
@@ -1024,6 +1583,11 @@ func main() {
 	})
 
 	btContinuousTrace = widget.NewButton("\u221E TRACE", func() {
+		traceOptions, err := selectedTraceOptions()
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
 		// Stop all previous traceroute goroutines
 		for _, cancel := range cancelFuncs {
 			cancel()
@@ -1060,129 +1624,86 @@ func main() {
 				entryField.SetText("")
 				entryField.SetPlaceHolder(placeHolderText2)
 				vBoxCenter.Add(entryField)
-				results, keys := pingotrace.DNSPTR(ctx, parsedInput)
+				// Only the first target is traced
+				result := pingotrace.DNSPTR(ctx, parsedInput[:1])[0]
 
 				var ipAddr, host string
-				for key, value := range results {
-					if key == keys[0] {
-						if pingotrace.CheckIPv4(key) {
-							ipAddr = key
-							host = value[0].(string)
-							if value[len(value)-1] == false {
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, ipAddr))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							} else {
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, host))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							}
-
-						} else {
-							if value[len(value)-1] == false {
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetPlaceHolder(fmt.Sprintf("%s: %s", key, value[0].(string)))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							} else {
-								host = key
-								ipAddr = value[0].(string)
-								vBoxCenter.RemoveAll()
-								win.Resize(fyne.NewSize(980, 537))
-								entryField = newTappableEntry("")
-								entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", host, ipAddr))
-								entryField.SetMinRowsVisible(minRowVisible)
-								vBoxCenter.Add(entryField)
-								hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
-								mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
-								win.SetContent(mainBox)
-								win.Resize(fyne.NewSize(980, 537))
-							}
+				key := result.Input
+				if pingotrace.CheckIP(key) {
+					ipAddr = key
+					host = result.Answer()
+					if !result.OK() {
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, ipAddr))
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
+					} else {
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", ipAddr, host))
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
+					}
 
-						}
-						break
+				} else {
+					if !result.OK() {
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetPlaceHolder(result.String())
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
+					} else {
+						host = key
+						ipAddr = result.Answer()
+						vBoxCenter.RemoveAll()
+						win.Resize(fyne.NewSize(980, 537))
+						entryField = newTappableEntry("")
+						entryField.SetText(fmt.Sprintf("Traceroute to %s [%s]:\n\n", host, ipAddr))
+						entryField.SetMinRowsVisible(minRowVisible)
+						vBoxCenter.Add(entryField)
+						hBoxTop = container.NewHBox(btStopBack, layout.NewSpacer(), btDark, btLight)
+						mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
+						win.SetContent(mainBox)
+						win.Resize(fyne.NewSize(980, 537))
 					}
+
 				}
 				tracerouteDst := entryField.Text
 				if ipAddr != "" {
-					maxHops := 30
-					timeout := 1 * time.Second
-					// Wrap the traceroute function to receive the output
-					traceOutputChan1 := make(chan []string, maxHops)
-					// Goroutine
+					tracer := pingotrace.NewTracer(traceOptions)
 
+					// MTR mode: keep probing every hop and redraw the per-hop statistics in place
+					monitorChan := make(chan []pingotrace.MonitorHop)
 					go func() {
-						for {
-							// Check if context has been cancelled before starting a new trace.
-							select {
-							case <-ctx.Done():
-								return
-							default:
-								// If context not cancelled, continue to trace.
-								pingotrace.Trace(ipAddr, maxHops, timeout, ctx, traceOutputChan1)
-								time.Sleep(5 * time.Second)
-
-								// Before updating the UI, check again if the context has been cancelled.
-								select {
-								case <-ctx.Done():
-									return
-								default:
-									entryField.SetText("")
-									entryField.Refresh()
-									entryField.SetText(tracerouteDst)
-								}
-							}
+						if err := tracer.Monitor(ctx, ipAddr, time.Second, monitorChan); err != nil {
+							entryField.SetText(entryField.Text + err.Error() + "\n")
 						}
 					}()
-
-					// Start another goroutine to update the display as soon as data becomes available in the outputCh
 					go func() {
-						for {
-							select {
-							case line, ok := <-traceOutputChan1:
-								if !ok {
-									return
-								}
-								if !shouldUpdate {
-									cancel() // cancel the context
-									return
-								}
-								result := fmt.Sprintf("%2s\t", line[0])
-								for i := 2; i < len(line); i++ {
-									if line[i] == "*" {
-										result += fmt.Sprintf("%-10s      \t", line[i]) // Add 6 more spaces after "*"
-									} else {
-										result += fmt.Sprintf("%-10s\t", line[i])
-									}
-								}
-								result += fmt.Sprintf("%-45s", line[1])
-								entryField.SetText(entryField.Text + result + "\n")
-								entryField.Refresh() // Notify Fyne to repaint the widget
-							case <-ctx.Done():
-								// context canceled
-								return
+						for hops := range monitorChan {
+							if !shouldUpdate {
+								cancel() // cancel the context
+								continue
 							}
+							entryField.SetText(tracerouteDst + formatMonitor(hops))
+							entryField.Refresh() // Notify Fyne to repaint the widget
 						}
 					}()
 				}
@@ -1195,6 +1716,11 @@ func main() {
 		entryField.SetText(resultText)
 	})
 
+	btFlushDNS = widget.NewButton("FLUSH DNS", func() {
+		stats := pingotrace.FlushDNSCache()
+		dialog.ShowInformation("DNS cache flushed", fmt.Sprintf("Removed %d cached lookups.\nCache hits: %d\nCache misses: %d", stats.Entries, stats.Hits, stats.Misses), win)
+	})
+
 	btMainClear = widget.NewButton("CLEAR", func() {
 		entryField.SetText("")
 		vBoxCenter.RemoveAll()
@@ -1226,7 +1752,7 @@ func main() {
 	vBoxCenter.Add(entryField)
 	btDark = widget.NewButton("DARK", setDarkMode)
 
-	hBoxTop = container.NewHBox(btParser, btDNSPTRLookup, btDNSPTRtoIP, btPing, btTrace, btPinGoTrace, btContinuousTrace, btIPConfig, btMainClear, btLicense, layout.NewSpacer(), btDark, btLight)
+	hBoxTop = container.NewHBox(btParser, btDNSPTRLookup, btDNSPTRtoIP, dnsServerEntry, btFCrDNS, btDNSQuery, dnsTypeEntry, btDNSTrace, btTLS, btPing, btPingOptions, btTrace, traceMethodEntry, btPMTU, btPinGoTrace, btContinuousTrace, btIPConfig, btFlushDNS, btMainClear, btLicense, layout.NewSpacer(), btDark, btLight)
 	mainBox = container.NewBorder(hBoxTop, nil, nil, nil, vBoxCenter)
 
 	win.SetContent(mainBox)
@@ -1291,6 +1817,62 @@ func (m *myTheme) SetDark(dark bool) {
 	m.dark = dark
 }
 
+// newSortableTable shows rows under a header row whose cells sort the rows by their column when tapped,
+// tapping the same header again reverses the order. Cells holding IP addresses sort numerically.
+func newSortableTable(header []string, rows [][]string) *widget.Table {
+	sortColumn, descending := -1, false
+	var table *widget.Table
+	table = widget.NewTable(
+		func() (int, int) { return len(rows), len(header) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			cell.(*widget.Label).SetText(rows[id.Row][id.Col])
+		},
+	)
+	table.ShowHeaderRow = true
+	table.CreateHeader = func() fyne.CanvasObject { return widget.NewButton("", nil) }
+	table.UpdateHeader = func(id widget.TableCellID, template fyne.CanvasObject) {
+		button := template.(*widget.Button)
+		text := header[id.Col]
+		if id.Col == sortColumn && descending {
+			text += " (desc)"
+		} else if id.Col == sortColumn {
+			text += " (asc)"
+		}
+		button.SetText(text)
+		button.OnTapped = func() {
+			if sortColumn == id.Col {
+				descending = !descending
+			} else {
+				sortColumn, descending = id.Col, false
+			}
+			sort.SliceStable(rows, func(i, j int) bool {
+				a, b := rows[i][sortColumn], rows[j][sortColumn]
+				if descending {
+					a, b = b, a
+				}
+				ipA, errA := netip.ParseAddr(a)
+				ipB, errB := netip.ParseAddr(b)
+				if errA == nil && errB == nil {
+					return ipA.Less(ipB)
+				}
+				return a < b
+			})
+			table.Refresh()
+		}
+	}
+
+	// Fit every column to its widest cell
+	for col := range header {
+		width := widget.NewButton(header[col]+" (desc)", nil).MinSize().Width
+		for _, row := range rows {
+			width = max(width, widget.NewLabel(row[col]).MinSize().Width)
+		}
+		table.SetColumnWidth(col, width)
+	}
+	return table
+}
+
 func createTable(rows, cols int) *fyne.Container {
 	table := container.NewGridWithColumns(cols)
 
@@ -1304,6 +1886,336 @@ func createTable(rows, cols int) *fyne.Container {
 	return table
 }
 
+// formatHop formats a traceroute hop for the trace output, one line per responder.
+// Each line shows only the RTTs of the probes that responder answered; timeouts are shown on the first line.
+// MPLS labels and interface information quoted by the responder follow on lines of their own.
+func formatHop(hop pingotrace.Hop) string {
+	responders := hop.Responders
+	if len(responders) == 0 {
+		// Every probe timed out, show them on a single line
+		responders = []pingotrace.Responder{{}}
+	}
+
+	var lines []string
+	for r, responder := range responders {
+		result := "  \t"
+		if r == 0 {
+			result = fmt.Sprintf("%2d\t", hop.TTL)
+		}
+		if len(hop.Probes) > maxProbeColumns {
+			// Enumerating load-balanced paths sends too many probes for one column each
+			result += fmt.Sprintf("%-34s\t", probeSummary(hop, responder))
+		} else {
+			for i, probe := range hop.Probes {
+				switch {
+				case probe.Reply == pingotrace.ProbeTimeout && r == 0:
+					result += fmt.Sprintf("%-10s      \t", probe.Label()) // Add 6 more spaces after "*"
+				case probe.Reply != pingotrace.ProbeTimeout && containsInt(responder.Probes, i):
+					result += fmt.Sprintf("%-10s\t", probe.Label())
+				default:
+					result += fmt.Sprintf("%-10s      \t", "")
+				}
+			}
+		}
+		if responder.Addr == nil {
+			result += fmt.Sprintf("%-45s", hop.Host())
+		} else {
+			result += fmt.Sprintf("%-45s", responder.Host())
+		}
+		if r == 0 {
+			// Tell an open TCP port from a closed one
+			for _, probe := range hop.Probes {
+				if probe.Reply == pingotrace.ProbeSynAck {
+					result += "\t(port open)"
+					break
+				} else if probe.Reply == pingotrace.ProbeReset {
+					result += "\t(port closed)"
+					break
+				}
+			}
+		}
+		if r == 0 && hop.Noise > 0 {
+			result += fmt.Sprintf("\t(%d stray replies ignored)", hop.Noise)
+		}
+		lines = append(lines, result)
+
+		// MPLS labels and interface information the router added to its answers, each line once
+		var extensions []string
+		for _, i := range responder.Probes {
+			for _, extension := range hop.Probes[i].Extensions() {
+				if !contains(extensions, extension) {
+					extensions = append(extensions, extension)
+				}
+			}
+		}
+		for _, extension := range extensions {
+			lines = append(lines, "  \t     "+extension)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatPMTU formats the outcome of a path MTU discovery: the MTU, who reported it,
+// and the trace with packets one byte too large that locates the hop where the MTU shrinks.
+func formatPMTU(result pingotrace.PMTUResult) string {
+	var lines []string
+	atLeast := ""
+	if result.Capped {
+		atLeast = "at least "
+	}
+	lines = append(lines, fmt.Sprintf("Path MTU: %s%d bytes (%d bytes of echo data), found with %d probes",
+		atLeast, result.MTU, result.MTU-result.Headers, result.Probes))
+	if result.ReportedMTU > 0 {
+		lines = append(lines, fmt.Sprintf("Next-hop MTU %d reported by %s", result.ReportedMTU, result.ReportedBy))
+	}
+
+	switch {
+	case result.Capped:
+	case result.Local:
+		lines = append(lines, "The MTU is that of the local interface")
+	case result.Bottleneck == nil:
+		lines = append(lines, "The hop where the MTU shrinks could not be located")
+	case result.BlackHole:
+		lines = append(lines, fmt.Sprintf("Larger packets vanish after hop %d: %s (no Fragmentation Needed, PMTU black hole)",
+			result.Bottleneck.TTL, result.Bottleneck.Host()))
+	default:
+		lines = append(lines, fmt.Sprintf("The MTU shrinks after hop %d: %s", result.Bottleneck.TTL, result.Bottleneck.Host()))
+	}
+
+	if len(result.Hops) > 0 {
+		lines = append(lines, "", fmt.Sprintf("Traceroute with %d-byte packets:", result.MTU+1))
+		for _, hop := range result.Hops {
+			lines = append(lines, formatHop(hop))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// formatDNSComparisons formats the answers of several DNS servers as a table with one column per server.
+// Each cell holds the records or the status of the answer and its response time;
+// cells that differ from the first server's answer are marked with "*".
+func formatDNSComparisons(comparisons []pingotrace.DNSComparison) string {
+	if len(comparisons) == 0 {
+		return ""
+	}
+	servers := comparisons[0].Servers
+	header := []string{"Input", "Type"}
+	for _, server := range servers {
+		header = append(header, pingotrace.DNSServerName(server))
+	}
+	rows := [][]string{header}
+	differing := 0
+	for _, comparison := range comparisons {
+		row := []string{comparison.Input, comparison.Type}
+		for i, answer := range comparison.Answers {
+			cell := strings.Join(answer.Data(), ", ")
+			if cell == "" {
+				cell = answer.Status()
+			}
+			cell += fmt.Sprintf(" (%.1f ms)", float64(answer.RTT)/float64(time.Millisecond))
+			if !comparison.Agrees(i) {
+				cell = "* " + cell
+			}
+			row = append(row, cell)
+		}
+		if comparison.Differs() {
+			differing++
+		}
+		rows = append(rows, row)
+	}
+
+	// Pad every column to its widest cell
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len([]rune(cell)))
+		}
+	}
+	var lines []string
+	for _, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			line.WriteString(cell + strings.Repeat(" ", widths[i]-len([]rune(cell))+3))
+		}
+		lines = append(lines, strings.TrimRight(line.String(), " "))
+	}
+	lines = append(lines, "", fmt.Sprintf("%d of %d queries answered differently; * marks answers that differ from %s",
+		differing, len(comparisons), pingotrace.DNSServerName(servers[0])))
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// formatDNSAnswer formats the answer to a DNS query like dig: a status line with the response code,
+// server and response time, the CNAME chain, and every record of the answer, or the authority
+// records of a negative answer.
+func formatDNSAnswer(answer pingotrace.DNSAnswer) string {
+	status := fmt.Sprintf("%s %s: %s from %s in %v", answer.Name, answer.Type, answer.Status(), answer.Server,
+		answer.RTT.Round(time.Microsecond*100))
+	if answer.Authoritative {
+		status += ", authoritative"
+	}
+	if answer.TCP {
+		status += ", truncated over UDP, repeated over TCP"
+	}
+	lines := []string{status}
+	if answer.Err != nil {
+		lines = append(lines, "  "+answer.Err.Error())
+		return strings.Join(lines, "\n") + "\n"
+	}
+	if len(answer.CNAMEChain) > 0 {
+		lines = append(lines, "  CNAME chain: "+strings.Join(answer.CNAMEChain, " -> "))
+	}
+	for _, record := range answer.Records {
+		lines = append(lines, "  "+record.String())
+	}
+	if len(answer.Records) == 0 {
+		for _, record := range answer.Authority {
+			lines = append(lines, "  Authority: "+record.String())
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// formatDNSTrace formats a delegation trace: the reply of every server of each zone from the root down,
+// the NS and glue records of the referral followed, and the final answer.
+func formatDNSTrace(trace pingotrace.DNSTrace) string {
+	lines := []string{fmt.Sprintf("%s %s: delegation from the root", trace.Name, trace.Type)}
+	for _, level := range trace.Levels {
+		lines = append(lines, "", "Zone "+level.Zone)
+		nameWidth, addrWidth := 0, 0
+		for _, reply := range level.Replies {
+			nameWidth = max(nameWidth, len(reply.Server.Name))
+			addrWidth = max(addrWidth, len(serverAddress(reply.Server)))
+		}
+		for _, reply := range level.Replies {
+			line := fmt.Sprintf("  %-*s  %-*s  %-11s %s", nameWidth, reply.Server.Name, addrWidth, serverAddress(reply.Server),
+				reply.Outcome, reply.Detail())
+			if reply.Outcome != pingotrace.DNSTraceError && reply.Outcome != pingotrace.DNSTraceNoResponse {
+				line += fmt.Sprintf(" in %v", reply.Answer.RTT.Round(time.Microsecond*100))
+			}
+			lines = append(lines, line)
+		}
+		if level.Next >= 0 {
+			referral := level.Replies[level.Next]
+			lines = append(lines, "  Referral from "+referral.Server.Name+":")
+			for _, record := range slices.Concat(referral.Answer.Authority, referral.Answer.Additional) {
+				lines = append(lines, "    "+record.String())
+			}
+		}
+	}
+
+	lines = append(lines, "")
+	if trace.Answer == nil {
+		lines = append(lines, "Trace failed: "+trace.Err.Error())
+		return strings.Join(lines, "\n") + "\n"
+	}
+	answer := trace.Answer.Answer
+	lines = append(lines, fmt.Sprintf("Answer from %s: %s", trace.Answer.Server.Name, answer.Status()))
+	for _, record := range answer.Records {
+		lines = append(lines, "  "+record.String())
+	}
+	if len(answer.Records) == 0 {
+		for _, record := range answer.Authority {
+			lines = append(lines, "  Authority: "+record.String())
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// serverAddress returns the address of a name server as shown by formatDNSTrace, marking addresses that did not come as glue.
+func serverAddress(server pingotrace.NameServer) string {
+	if server.Addr != "" && !server.Glue {
+		return server.Addr + " (no glue)"
+	}
+	return server.Addr
+}
+
+// formatTLS formats the outcome of a TLS inspection as a table of "field: value" rows,
+// one group of rows per certificate of the chain.
+func formatTLS(result pingotrace.TLSResult) string {
+	lines := []string{result.Target + ":"}
+	row := func(indent, field, value string) {
+		lines = append(lines, fmt.Sprintf("%s%-*s %s", indent, 22-len(indent), field+":", value))
+	}
+	if result.Err != nil {
+		row("  ", "Error", result.Err.Error())
+		return strings.Join(lines, "\n") + "\n"
+	}
+	verdict := func(err error) string {
+		if err == nil {
+			return "OK"
+		}
+		return "FAILED, " + err.Error()
+	}
+	stapled := "no"
+	if result.OCSPStapled {
+		stapled = "yes"
+	}
+	row("  ", "Address", result.Addr)
+	row("  ", "Protocol", result.Version)
+	row("  ", "Cipher suite", result.CipherSuite)
+	row("  ", "OCSP stapling", stapled)
+	row("  ", "Hostname check", result.ServerName+": "+verdict(result.HostnameErr))
+	row("  ", "Chain trust", verdict(result.VerifyErr))
+	for i, cert := range result.Chain {
+		role := "Intermediate"
+		switch {
+		case i == 0:
+			role = "Leaf"
+		case cert.Subject == cert.Issuer:
+			role = "Root"
+		}
+		row("  ", fmt.Sprintf("Certificate %d", i), role)
+		row("    ", "Subject", cert.Subject)
+		if len(cert.SANs) > 0 {
+			row("    ", "SANs", strings.Join(cert.SANs, ", "))
+		}
+		row("    ", "Issuer", cert.Issuer)
+		row("    ", "Not before", cert.NotBefore.Format(time.RFC3339))
+		row("    ", "Not after", cert.NotAfter.Format(time.RFC3339))
+		expiry := fmt.Sprintf("%d", cert.DaysLeft)
+		if cert.DaysLeft < 0 {
+			expiry = fmt.Sprintf("%d (expired)", cert.DaysLeft)
+		}
+		row("    ", "Days to expiry", expiry)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// formatMonitor formats the per-hop statistics of MTR mode as a table.
+func formatMonitor(hops []pingotrace.MonitorHop) string {
+	lines := []string{pingotrace.MonitorHeader}
+	for _, hop := range hops {
+		lines = append(lines, hop.String())
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Most probes of a hop shown in their own column of the trace output
+const maxProbeColumns = 3
+
+// probeSummary returns how many of the hop's probes a responder answered and their average RTT.
+func probeSummary(hop pingotrace.Hop, responder pingotrace.Responder) string {
+	if responder.Addr == nil {
+		return fmt.Sprintf("%d probes: *", len(hop.Probes))
+	}
+	var sum time.Duration
+	for _, i := range responder.Probes {
+		sum += hop.Probes[i].RTT
+	}
+	avg := sum / time.Duration(len(responder.Probes))
+	return fmt.Sprintf("%d/%d flows, avg RTT: %v", len(responder.Probes), len(hop.Probes), avg.Round(time.Millisecond))
+}
+
+// containsInt checks if an int is present in a slice of ints.
+func containsInt(s []int, n int) bool {
+	for _, v := range s {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
 // contains checks if a string is present in a slice of strings.
 func contains(s []string, str string) bool {
 	for _, v := range s {